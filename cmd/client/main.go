@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	// Uncomment for profiling
 	// _ "net/http/pprof"
@@ -20,8 +21,10 @@ func main() {
 	// }()
 	args := os.Args[1:]
 	name := "andrew"
+	password := ""
 	host := "localhost"
 	port := 5555
+	world := ""
 	var e error
 	if len(args) >= 1 {
 		name = args[0]
@@ -35,16 +38,26 @@ func main() {
 			panic(e)
 		}
 	}
+	if len(args) >= 4 {
+		password = args[3]
+	}
+	if len(args) >= 5 {
+		world = args[4]
+	}
 	if len(args) == 0 {
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Enter your name DO NOT LEAVE BLANK: ")
 
 		namestr, _ := reader.ReadString('\n')
 		if strings.TrimSpace(namestr) != "" {
-			name = namestr
+			name = strings.TrimSpace(namestr)
 		}
 		reader = bufio.NewReader(os.Stdin)
-		fmt.Print("Enter host (leave blank for 'localhost'): ")
+		fmt.Print("Enter your password: ")
+		passwordstr, _ := reader.ReadString('\n')
+		password = strings.TrimSpace(passwordstr)
+		reader = bufio.NewReader(os.Stdin)
+		fmt.Print("Enter host (leave blank for 'localhost', 'lan' to search the local network, tls://host for TLS): ")
 		hoststr, _ := reader.ReadString('\n')
 		if strings.TrimSpace(hoststr) != "" {
 			host = strings.TrimSpace(hoststr)
@@ -58,6 +71,50 @@ func main() {
 				panic(e)
 			}
 		}
+		reader = bufio.NewReader(os.Stdin)
+		fmt.Print("Enter world (leave blank for the server's main world): ")
+		worldstr, _ := reader.ReadString('\n')
+		world = strings.TrimSpace(worldstr)
+	}
+	if host == "lan" {
+		host, port = pickLANServer(len(args) == 0)
+	}
+	client.Start(name, password, host, port, world, nil)
+}
+
+// pickLANServer lists servers on the local network and lets the user choose one,
+// or picks the first when not interactive
+func pickLANServer(interactive bool) (string, int) {
+	fmt.Println("Searching the local network...")
+	servers, e := client.DiscoverServers(3 * time.Second)
+	if e != nil {
+		panic(e)
+	}
+	if len(servers) == 0 {
+		fmt.Println("No servers found")
+		os.Exit(1)
+	}
+	for i, s := range servers {
+		worlds := s.World
+		if len(s.Worlds) > 0 {
+			worlds = strings.Join(s.Worlds, ", ")
+		}
+		fmt.Printf("%v) %v - worlds %v, %v players (%v:%v)\n", i+1, s.Name, worlds, s.Players, s.Address(), s.Port)
+	}
+	choice := 1
+	if interactive {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Enter server number (leave blank for 1): ")
+		choicestr, _ := reader.ReadString('\n')
+		if strings.TrimSpace(choicestr) != "" {
+			var e error
+			choice, e = strconv.Atoi(strings.TrimSpace(choicestr))
+			if e != nil || choice < 1 || choice > len(servers) {
+				fmt.Println("Invalid server number")
+				os.Exit(1)
+			}
+		}
 	}
-	client.Start(name, host, port, nil)
+	s := servers[choice-1]
+	return s.Address(), s.Port
 }
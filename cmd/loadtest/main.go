@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/bot"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+	"github.com/jeffbaumes/buildorb/pkg/server"
+)
+
+var (
+	players    = flag.Int("n", 10, "number of simulated players")
+	duration   = flag.Duration("duration", time.Minute, "how long to run once everyone is connected")
+	ramp       = flag.Duration("ramp", 100*time.Millisecond, "delay between connecting players")
+	host       = flag.String("host", "", "server to test, tls://host for TLS, or empty to start a local one")
+	port       = flag.Int("port", 5556, "server port")
+	metrics    = flag.String("metrics", "", "metrics URL of the server to sample, set for a local server")
+	knownHosts = flag.String("known-hosts", common.KnownHostsFile, "file TLS server certificates are pinned in")
+	dir        = flag.String("dir", "", "directory for the local server, a new temporary one if empty")
+	world      = flag.String("world", "loadtest", "world for the local server")
+	seed       = flag.Int("seed", 1, "seed for the local server")
+	serve      = flag.Bool("serve", false, "only run the server, used for the local server process")
+	spread     = flag.Float64("spread", 0.2, "how far around the planet players are spread at the start, in radians")
+	walk       = flag.Float64("walk", 0.7, "fraction of time players spend walking")
+	chatRate   = flag.Float64("chat", 0.1, "chat messages per second per player")
+	editRate   = flag.Float64("edit", 1, "block edits per second per player")
+	pingRate   = flag.Float64("ping", 1, "latency probes per second per player")
+	out        = flag.String("out", "", "file to write the JSON summary to")
+)
+
+func main() {
+	flag.Parse()
+	if *serve {
+		server.Start(*world, *seed, *port)
+		return
+	}
+	if *host == "" {
+		*host = "localhost"
+		stop := startServer()
+		defer stop()
+		waitForServer(*host, *port)
+	}
+
+	s := newStats()
+	var wg, ready sync.WaitGroup
+	done := make(chan struct{})
+	start := time.Now()
+	for i := 0; i < *players; i++ {
+		wg.Add(1)
+		ready.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			simulate(fmt.Sprintf("bot%v", i), s, &ready, done)
+		}(i)
+		time.Sleep(*ramp)
+	}
+	// Measure from when everyone has connected or given up
+	ready.Wait()
+	log.Printf("Connected %v of %v players in %v\n", s.players(), *players, time.Since(start))
+
+	s.startSampling(*metrics)
+	time.Sleep(*duration)
+	close(done)
+	wg.Wait()
+	s.stopSampling()
+
+	summary := s.summary()
+	b, e := json.MarshalIndent(summary, "", "  ")
+	if e != nil {
+		panic(e)
+	}
+	fmt.Println(string(b))
+	if *out != "" {
+		e = ioutil.WriteFile(*out, b, 0644)
+		if e != nil {
+			panic(e)
+		}
+	}
+}
+
+// startServer runs a local server in a separate process, so that sampling its
+// metrics measures the server without the simulated players. Players may
+// teleport, which spreads them out at the start. It returns a function that
+// stops the server and removes its directory if it was a temporary one.
+func startServer() func() {
+	serverDir := *dir
+	temporary := serverDir == ""
+	if temporary {
+		d, e := ioutil.TempDir("", "loadtest")
+		if e != nil {
+			panic(e)
+		}
+		serverDir = d
+	}
+	e := os.MkdirAll(filepath.Join(serverDir, "worlds"), 0755)
+	if e != nil {
+		panic(e)
+	}
+	metricsPort := *port + 1
+	config := fmt.Sprintf("metrics_port=%v;default_role=admin", metricsPort)
+	e = ioutil.WriteFile(filepath.Join(serverDir, "server.buildorb"), []byte(config), 0644)
+	if e != nil {
+		panic(e)
+	}
+	if *metrics == "" {
+		*metrics = fmt.Sprintf("http://localhost:%v/metrics", metricsPort)
+	}
+	exe, e := os.Executable()
+	if e != nil {
+		panic(e)
+	}
+	cmd := exec.Command(exe, "-serve", "-world", *world, "-seed", strconv.Itoa(*seed), "-port", strconv.Itoa(*port))
+	cmd.Dir = serverDir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	e = cmd.Start()
+	if e != nil {
+		panic(e)
+	}
+	log.Printf("Started server in %v\n", serverDir)
+	return func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if temporary {
+			os.RemoveAll(serverDir)
+		}
+	}
+}
+
+// waitForServer waits until the server accepts connections
+func waitForServer(host string, port int) {
+	for i := 0; i < 100; i++ {
+		conn, e := net.Dial("tcp", fmt.Sprintf("%v:%v", host, port))
+		if e == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Fatal("Server did not start")
+}
+
+// simulate connects one player and acts randomly until done. Ready is told
+// once the player has connected and spread out, or failed to connect.
+func simulate(name string, s *stats, ready *sync.WaitGroup, done chan struct{}) {
+	raw, e := common.DialServer(*host, *port, *knownHosts)
+	if e != nil {
+		s.addError("connect", e)
+		ready.Done()
+		return
+	}
+	conn := &countingConn{Conn: raw}
+	t := time.Now()
+	b, e := bot.Connect(conn, name, "loadtest")
+	s.addCall("connect", time.Since(t), e)
+	if e != nil {
+		ready.Done()
+		return
+	}
+	b.OnCall = s.addCall
+	defer func() {
+		b.Close()
+		s.addClient(atomic.LoadInt64(&conn.read), atomic.LoadInt64(&conn.written), time.Since(t))
+	}()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if *spread > 0 {
+		if e := spreadOut(b, rnd); e != nil {
+			s.addError("spread", e)
+		}
+	}
+	s.addPlayer()
+	ready.Done()
+	speed := b.WalkSpeed()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-b.Done():
+			s.addError("disconnect", fmt.Errorf("%v was disconnected", name))
+			return
+		case <-tick.C:
+		}
+		if chance(rnd, 0.5) {
+			if rnd.Float64() < *walk {
+				b.Look(randomHeading(rnd, b.Position()))
+				b.Move(speed, 0)
+				b.Jump(chance(rnd, 1))
+			} else {
+				b.Stop()
+			}
+		}
+		if chance(rnd, *chatRate) {
+			b.Chat("hello")
+		}
+		if chance(rnd, *editRate) {
+			// Dig or build on the ground a little way ahead
+			down := b.Position().Normalize().Mul(-1)
+			b.Look(randomHeading(rnd, b.Position()).Add(down))
+			if rnd.Intn(2) == 0 {
+				b.Break()
+			} else {
+				b.Place(common.Stone)
+			}
+		}
+		if chance(rnd, *pingRate) {
+			var serverTime float64
+			b.Call("API.GetTime", 0, &serverTime)
+		}
+	}
+}
+
+// spreadOut teleports a player to a random point above the ground near the spawn
+// point so everyone does not crowd into the same chunks
+func spreadOut(b *bot.Bot, rnd *rand.Rand) error {
+	home := b.Planet(0)
+	angle := rnd.Float64() * 2 * math.Pi
+	dist := math.Sqrt(rnd.Float64()) * *spread
+	lat := dist * math.Sin(angle)
+	lon := dist * math.Cos(angle)
+	r := float64(home.Radius) + 5
+	pos := mgl32.Vec3{
+		float32(r * math.Cos(lat) * math.Cos(lon)),
+		float32(r * math.Cos(lat) * math.Sin(lon)),
+		float32(r * math.Sin(lat)),
+	}
+	e := b.Chat(fmt.Sprintf("/tp %v %v %v", pos[0], pos[1], pos[2]))
+	if e != nil {
+		return e
+	}
+	// Commands answer in chat, so check that the player moved
+	for i := 0; i < 20; i++ {
+		if b.Position().Normalize().Sub(pos.Normalize()).Len() < 0.01 {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.New("teleport failed, the player may not have permission")
+}
+
+// randomHeading returns a random direction along the ground
+func randomHeading(rnd *rand.Rand, pos mgl32.Vec3) mgl32.Vec3 {
+	angle := rnd.Float64() * 2 * math.Pi
+	dir := mgl32.Vec3{float32(math.Cos(angle)), float32(math.Sin(angle)), float32(math.Cos(3 * angle))}
+	return common.ProjectToPlane(dir, pos.Normalize()).Normalize()
+}
+
+// chance returns true with the probability of an event that happens rate times per second in one tick
+func chance(rnd *rand.Rand, rate float64) bool {
+	return rnd.Float64() < rate/10
+}
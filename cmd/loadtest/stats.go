@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// countingConn counts the bytes passing through a connection
+type countingConn struct {
+	net.Conn
+	read, written int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, e := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, e
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, e := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, e
+}
+
+// Latency is a summary of how long one kind of call took, in milliseconds
+type Latency struct {
+	Count  int
+	Errors int
+	P50    float64
+	P90    float64
+	P99    float64
+	Max    float64
+}
+
+// Summary is the result of a load test run
+type Summary struct {
+	Players      int
+	Connected    int // players that connected, the rest failed to
+	Seconds      float64
+	Latency      map[string]Latency
+	Errors       map[string]int
+	ErrorSamples []string
+	// Bandwidth is per connected player
+	BytesInPerSec  float64
+	BytesOutPerSec float64
+	// The server figures come from its metrics, when they could be read
+	ServerCPUCores   float64 `json:",omitempty"`
+	ServerHeapMBPeak float64 `json:",omitempty"`
+	ServerSysMBPeak  float64 `json:",omitempty"`
+	ServerQueuePeak  float64 `json:",omitempty"`
+}
+
+// stats collects measurements from all simulated players
+type stats struct {
+	calls        map[string][]time.Duration
+	errors       map[string]int
+	errorSamples []string
+	bytesIn      int64
+	bytesOut     int64
+	joined       int
+	connected    time.Duration
+	start        time.Time
+	end          time.Time
+	metricsURL   string
+	cpuStart     float64
+	cpuEnd       float64
+	heapPeak     float64
+	sysPeak      float64
+	queuePeak    float64
+	sampled      bool
+	stop         chan struct{}
+	mutex        sync.Mutex
+}
+
+func newStats() *stats {
+	return &stats{
+		calls:  make(map[string][]time.Duration),
+		errors: make(map[string]int),
+		stop:   make(chan struct{}),
+	}
+}
+
+// addPlayer counts a player that connected
+func (s *stats) addPlayer() {
+	s.mutex.Lock()
+	s.joined++
+	s.mutex.Unlock()
+}
+
+// players returns how many players connected
+func (s *stats) players() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.joined
+}
+
+// maxErrorSamples is how many error messages are kept for the summary
+const maxErrorSamples = 20
+
+func (s *stats) addCall(method string, d time.Duration, e error) {
+	s.mutex.Lock()
+	s.calls[method] = append(s.calls[method], d)
+	s.mutex.Unlock()
+	if e != nil {
+		s.addError(method, e)
+	}
+}
+
+func (s *stats) addError(kind string, e error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors[kind]++
+	if len(s.errorSamples) < maxErrorSamples {
+		s.errorSamples = append(s.errorSamples, kind+": "+e.Error())
+	}
+}
+
+func (s *stats) addClient(read, written int64, connected time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bytesIn += read
+	s.bytesOut += written
+	s.connected += connected
+}
+
+// scrape reads the unlabeled samples from a Prometheus text metrics page
+func scrape(url string) (map[string]float64, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, e := client.Get(url)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics returned %v", resp.Status)
+	}
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if v, e := strconv.ParseFloat(fields[1], 64); e == nil {
+			samples[fields[0]] = v
+		}
+	}
+	return samples, scanner.Err()
+}
+
+// sample reads the server metrics and keeps the peaks
+func (s *stats) sample() (map[string]float64, error) {
+	m, e := scrape(s.metricsURL)
+	if e != nil {
+		s.addError("metrics", e)
+		return nil, e
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if v := m["go_memstats_heap_alloc_bytes"]; v > s.heapPeak {
+		s.heapPeak = v
+	}
+	if v := m["go_memstats_sys_bytes"]; v > s.sysPeak {
+		s.sysPeak = v
+	}
+	if v := m["buildorb_broadcast_queue_depth"]; v > s.queuePeak {
+		s.queuePeak = v
+	}
+	return m, nil
+}
+
+// startSampling starts measuring. The server's CPU and memory are read from its
+// metrics page every second, if there is one.
+func (s *stats) startSampling(metricsURL string) {
+	s.metricsURL = metricsURL
+	s.start = time.Now()
+	if metricsURL == "" {
+		return
+	}
+	m, e := s.sample()
+	if e != nil {
+		return
+	}
+	s.cpuStart = m["go_cpu_seconds_total"]
+	s.sampled = true
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(time.Second):
+			}
+			s.sample()
+		}
+	}()
+}
+
+func (s *stats) stopSampling() {
+	close(s.stop)
+	s.end = time.Now()
+	if !s.sampled {
+		return
+	}
+	m, e := s.sample()
+	if e != nil {
+		s.sampled = false
+		return
+	}
+	s.cpuEnd = m["go_cpu_seconds_total"]
+}
+
+func (s *stats) summary() Summary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	seconds := s.end.Sub(s.start).Seconds()
+	sum := Summary{
+		Players:      *players,
+		Connected:    s.joined,
+		Seconds:      seconds,
+		Latency:      make(map[string]Latency),
+		Errors:       s.errors,
+		ErrorSamples: s.errorSamples,
+	}
+	if s.connected > 0 {
+		sum.BytesInPerSec = float64(s.bytesIn) / s.connected.Seconds()
+		sum.BytesOutPerSec = float64(s.bytesOut) / s.connected.Seconds()
+	}
+	if s.sampled && seconds > 0 {
+		sum.ServerCPUCores = (s.cpuEnd - s.cpuStart) / seconds
+		sum.ServerHeapMBPeak = s.heapPeak / (1 << 20)
+		sum.ServerSysMBPeak = s.sysPeak / (1 << 20)
+		sum.ServerQueuePeak = s.queuePeak
+	}
+	for method, durations := range s.calls {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		sum.Latency[method] = Latency{
+			Count:  len(durations),
+			Errors: s.errors[method],
+			P50:    percentile(durations, 0.5),
+			P90:    percentile(durations, 0.9),
+			P99:    percentile(durations, 0.99),
+			Max:    percentile(durations, 1),
+		}
+	}
+	return sum
+}
+
+// percentile returns a percentile of sorted durations in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted)-1) + 0.5)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
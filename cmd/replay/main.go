@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jeffbaumes/buildorb/pkg/server"
+)
+
+// main serves a recording made with the server's record command. Connect a
+// client with any name and password to watch it as a spectator. Every viewer
+// can type /replay to control playback, as can the console.
+func main() {
+	args := os.Args[1:]
+	if len(args) < 1 {
+		fmt.Println("usage: replay <recording file> [port]")
+		os.Exit(1)
+	}
+	port := 5555
+	if len(args) >= 2 {
+		var e error
+		port, e = strconv.Atoi(args[1])
+		if e != nil {
+			panic(e)
+		}
+	}
+	server.EnableConsole()
+	server.Replay(args[0], port)
+}
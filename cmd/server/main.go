@@ -58,5 +58,6 @@ func main() {
 			}
 		}
 	}
+	server.EnableConsole()
 	server.Start(world, seed, port)
 }
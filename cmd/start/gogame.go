@@ -19,6 +19,7 @@ func main() {
 	sworld := "default"
 	play := "all"
 	name := "andrew"
+	password := ""
 	host := "localhost"
 	port := 5555
 
@@ -78,6 +79,9 @@ func main() {
 		if e != nil {
 			panic(e)
 		}
+		if len(args) >= 8 {
+			password = args[7]
+		}
 	}
 	if len(args) == 0 {
 		if play == "client" || play == "all" {
@@ -86,9 +90,13 @@ func main() {
 
 			namestr, _ := reader.ReadString('\n')
 			if strings.TrimSpace(namestr) != "" {
-				name = namestr
+				name = strings.TrimSpace(namestr)
 			}
 			reader = bufio.NewReader(os.Stdin)
+			fmt.Print("Enter your password: ")
+			passwordstr, _ := reader.ReadString('\n')
+			password = strings.TrimSpace(passwordstr)
+			reader = bufio.NewReader(os.Stdin)
 			fmt.Print("Enter host for client (leave blank for 'localhost'): ")
 			hoststr, _ := reader.ReadString('\n')
 			if strings.TrimSpace(hoststr) != "" {
@@ -114,6 +122,6 @@ func main() {
 	}
 	time.Sleep(1)
 	if play == "client" || play == "all" {
-		client.Start(name, host, port)
+		client.Start(name, password, host, port, "", nil)
 	}
 }
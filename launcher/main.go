@@ -13,6 +13,7 @@ import (
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	"github.com/jeffbaumes/buildorb/pkg/client"
+	"github.com/jeffbaumes/buildorb/pkg/common"
 	"github.com/jeffbaumes/buildorb/pkg/server"
 )
 
@@ -25,6 +26,34 @@ type ui struct {
 	profile int
 }
 
+// discovery is the result of searching the local network for servers
+type discovery struct {
+	servers []common.ServerAnnouncement
+	err     error
+}
+
+// lanWorld is one world hosted by a server on the local network
+type lanWorld struct {
+	server common.ServerAnnouncement
+	world  string
+}
+
+// listLANWorlds lists every world of the servers, or just the main world for
+// servers that do not announce their world list
+func listLANWorlds(servers []common.ServerAnnouncement) []lanWorld {
+	list := []lanWorld{}
+	for _, s := range servers {
+		worlds := s.Worlds
+		if len(worlds) == 0 {
+			worlds = []string{s.World}
+		}
+		for _, w := range worlds {
+			list = append(list, lanWorld{s, w})
+		}
+	}
+	return list
+}
+
 func windowSizeCallback(w *glfw.Window, wd, ht int) {
 	fwidth, fheight := gui.FramebufferSize(w)
 	gl.Viewport(0, 0, int32(fwidth), int32(fheight))
@@ -101,6 +130,8 @@ func main() {
 	defaulte := gui.NewButton(screen, "", -0.25, -0.4, 0.5, 0.2, 0.05, nil)
 	hoste := gui.NewEntry(screen, "", -0.55, -0.17, 0.5, 0.2, 0.05, nil)
 	gui.NewLabel(screen, "Host", -0.41, 0.08, 0.1)
+	passworde := gui.NewEntry(screen, "", 0.6, -0.17, 0.35, 0.2, 0.05, nil)
+	gui.NewLabel(screen, "Password", 0.62, 0.08, 0.1)
 	pickpro := gui.NewButton(screen, "Switch profile", -0.95, -0.95, 0.4, 0.2, 0.05, nil)
 	message := gui.NewLabel(screen, "", -0.5, -0.95, 0.1)
 
@@ -140,11 +171,57 @@ func main() {
 		}
 		profiles[ui.profile].host = hoste.Text
 	}
-	connecttoserver := func() {
-		client.Start(profiles[ui.profile].name, "friends123.tk", 1234, screen)
-
+	// The multiplayer button searches the local network in the background, then
+	// cycles through each world of the servers found
+	lanWorlds := []lanWorld{}
+	lanIndex := 0
+	var discovered chan discovery
+	var remoteWorld, remoteHost string
+	showLANWorld := func() {
+		l := lanWorlds[lanIndex]
+		lanIndex++
+		remoteWorld = l.world
+		remoteHost = l.server.Address()
+		worlde.Text = ""
+		hoste.Text = remoteHost
+		porte.Text = fmt.Sprintf("%v", l.server.Port)
+		message.Text = fmt.Sprintf("%v/%v: %v world %v (%v players)", lanIndex, len(lanWorlds), l.server.Name, l.world, l.server.Players)
 	}
-	serverb.Command = connecttoserver
+	findservers := func() {
+		if discovered != nil {
+			return
+		}
+		if lanIndex < len(lanWorlds) {
+			showLANWorld()
+			return
+		}
+		message.Text = "Searching the local network..."
+		discovered = make(chan discovery, 1)
+		go func(results chan discovery) {
+			servers, e := client.DiscoverServers(2 * time.Second)
+			results <- discovery{servers, e}
+		}(discovered)
+	}
+	// receiveServers shows the search results once they arrive, called from the UI loop
+	receiveServers := func() {
+		select {
+		case d := <-discovered:
+			discovered = nil
+			lanWorlds = listLANWorlds(d.servers)
+			lanIndex = 0
+			if d.err != nil {
+				message.Text = "ERROR: " + d.err.Error()
+				return
+			}
+			if len(lanWorlds) == 0 {
+				message.Text = "No servers found on the local network."
+				return
+			}
+			showLANWorld()
+		default:
+		}
+	}
+	serverb.Command = findservers
 	newp := func() {
 		message.Text = "Successfully created profile."
 		profiles = append(profiles, &profile{})
@@ -211,11 +288,16 @@ func main() {
 			saveProfile()
 			saveProfileFile()
 			if profiles[ui.profile].world == "" {
-				client.Start(profiles[ui.profile].name, profiles[ui.profile].host, profiles[ui.profile].port, screen)
+				// A world picked from the local network is only used for the server it came from
+				world := ""
+				if profiles[ui.profile].host == remoteHost {
+					world = remoteWorld
+				}
+				client.Start(profiles[ui.profile].name, passworde.Text, profiles[ui.profile].host, profiles[ui.profile].port, world, screen)
 			} else if profiles[ui.profile].world != "" {
 				go server.Start(profiles[ui.profile].world, 123, profiles[ui.profile].port)
 				time.Sleep(time.Second)
-				client.Start(profiles[ui.profile].name, profiles[ui.profile].host, profiles[ui.profile].port, screen)
+				client.Start(profiles[ui.profile].name, passworde.Text, profiles[ui.profile].host, profiles[ui.profile].port, "", screen)
 			}
 		}
 	}
@@ -227,6 +309,7 @@ func main() {
 	for !w.ShouldClose() {
 		gl.ClearColor(0.498, 1.000, 0.831, 1)
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		receiveServers()
 		screen.Update()
 		glfw.PollEvents()
 		w.SwapBuffers()
@@ -0,0 +1,207 @@
+package bot
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Position returns the bot's location
+func (b *Bot) Position() mgl32.Vec3 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Player.Location()
+}
+
+// Move sets how fast the bot walks forward and to the right, negative to go back or left
+func (b *Bot) Move(forward, right float32) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	p := b.Player
+	p.ForwardVel, p.BackVel = split(forward)
+	p.RightVel, p.LeftVel = split(right)
+}
+
+// WalkSpeed returns how fast the bot walks
+func (b *Bot) WalkSpeed() float32 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Player.WalkVel
+}
+
+// Fly sets how fast the bot rises, negative to sink, while flying
+func (b *Bot) Fly(up float32) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Player.UpVel, b.Player.DownVel = split(up)
+}
+
+// Stop stops all movement
+func (b *Bot) Stop() {
+	b.Move(0, 0)
+	b.Fly(0)
+	b.Jump(false)
+}
+
+// Jump holds or releases the jump key
+func (b *Bot) Jump(hold bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Player.HoldingJump = hold
+}
+
+// SetGameMode switches between the common game modes
+func (b *Bot) SetGameMode(mode int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Player.GameMode = mode
+}
+
+// Look points the bot in a direction
+func (b *Bot) Look(dir mgl32.Vec3) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Player.SetLookDir(dir)
+}
+
+// LookAt points the bot at a location
+func (b *Bot) LookAt(target mgl32.Vec3) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Player.SetLookDir(target.Sub(b.Player.Location()))
+}
+
+// target finds the first solid cell the bot is looking at and the cell in front of it
+func (b *Bot) target() (hit, front common.CellIndex, ok bool) {
+	planet := b.Player.Planet
+	increment := b.Player.LookDir().Mul(0.05)
+	pos := b.Player.Location()
+	front = common.CellIndex{Lon: -1, Lat: -1, Alt: -1}
+	hit = planet.CartesianToCellIndex(pos)
+	for i := 0; i < 100; i++ {
+		pos = pos.Add(increment)
+		next := planet.CartesianToCellIndex(pos)
+		if next == hit {
+			continue
+		}
+		front, hit = hit, next
+		cell := planet.CellIndexToCell(hit)
+		if cell != nil && cell.Material != common.Air {
+			return hit, front, true
+		}
+	}
+	return hit, front, false
+}
+
+// Place puts a cell of the material in front of the cell the bot is looking at
+func (b *Bot) Place(material int) error {
+	b.mutex.Lock()
+	_, front, ok := b.target()
+	planet := b.Player.Planet
+	b.mutex.Unlock()
+	if !ok || front.Lon == -1 {
+		return errNoTarget
+	}
+	return b.setCellMaterial(planet, front, material)
+}
+
+// Break clears the cell the bot is looking at
+func (b *Bot) Break() error {
+	b.mutex.Lock()
+	hit, _, ok := b.target()
+	planet := b.Player.Planet
+	b.mutex.Unlock()
+	if !ok {
+		return errNoTarget
+	}
+	return b.setCellMaterial(planet, hit, common.Air)
+}
+
+// setCellMaterial changes a cell locally and waits for the server to take the edit
+func (b *Bot) setCellMaterial(planet *common.Planet, ind common.CellIndex, material int) error {
+	b.mutex.Lock()
+	changed := planet.SetCellMaterial(ind, material, false)
+	b.mutex.Unlock()
+	if !changed {
+		return nil
+	}
+	var ret bool
+	e := b.call("API.SetCellMaterial", common.RPCSetCellMaterialArgs{
+		Planet:   planet.ID,
+		Index:    ind,
+		Material: material,
+	}, &ret)
+	if e == nil && !ret {
+		e = errRejected
+	}
+	return e
+}
+
+// SetCellMaterials changes many cells locally and waits for the server to take them all
+func (b *Bot) SetCellMaterials(edits []common.RPCSetCellMaterialArgs) error {
+	byPlanet := make(map[int][]common.RPCSetCellMaterialArgs)
+	for _, edit := range edits {
+		byPlanet[edit.Planet] = append(byPlanet[edit.Planet], edit)
+	}
+	b.mutex.Lock()
+	for id, planetEdits := range byPlanet {
+		if planet := b.Planets[id]; planet != nil {
+			planet.SetCellMaterials(planetEdits, false)
+		}
+	}
+	b.mutex.Unlock()
+	var ret bool
+	e := b.call("API.SetCellMaterials", common.RPCSetCellMaterialsArgs{Edits: edits}, &ret)
+	if e == nil && !ret {
+		e = errRejected
+	}
+	return e
+}
+
+// Chat sends a chat message from the bot. Messages starting with a slash are commands.
+func (b *Bot) Chat(text string) error {
+	var ret bool
+	return b.call("API.SendText", text, &ret)
+}
+
+// Hit damages another player
+func (b *Bot) Hit(target string) error {
+	var ret bool
+	return b.call("API.HitPlayer", common.HitPlayerArgs{From: b.Player.Name, Target: target, Amount: 1}, &ret)
+}
+
+// People returns the last known state of the other players
+func (b *Bot) People() []common.PlayerState {
+	b.peopleMutex.Lock()
+	defer b.peopleMutex.Unlock()
+	people := []common.PlayerState{}
+	for _, state := range b.people {
+		people = append(people, state)
+	}
+	return people
+}
+
+// followPlayer moves a spectating bot to the last known view of the player it follows.
+// The caller holds the bot's mutex.
+func (b *Bot) followPlayer() {
+	if b.following == "" || b.Player.GameMode != common.Spectator {
+		return
+	}
+	b.peopleMutex.Lock()
+	state, ok := b.people[b.following]
+	b.peopleMutex.Unlock()
+	planet := b.Planets[state.Planet]
+	if !ok || planet == nil {
+		return
+	}
+	b.Player.Planet = planet
+	b.Player.SetLocation(state.Position)
+	b.Player.SetLookDir(state.LookDir)
+}
+
+// split turns a signed speed into the positive and negative velocities the player uses
+func split(v float32) (pos, neg float32) {
+	if v < 0 {
+		return 0, -v
+	}
+	return v, 0
+}
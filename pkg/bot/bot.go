@@ -0,0 +1,272 @@
+package bot
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Bot timing settings
+const (
+	tickRate       = 20
+	updateInterval = 50 * time.Millisecond
+	clockSamples   = 8
+)
+
+// Timeout is how long a bot waits for the server to answer a call
+var Timeout = 15 * time.Second
+
+// KnownHosts is the file the certificates of TLS servers are pinned in the
+// first time a bot connects to them
+var KnownHosts = common.KnownHostsFile
+
+var (
+	errTimeout  = errors.New("call timed out")
+	errNoTarget = errors.New("no cell in reach")
+	errRejected = errors.New("edit rejected by server")
+)
+
+// Bot is a player without a window, driven from Go code
+type Bot struct {
+	Player *common.Player
+	// Planets is replaced when the bot changes worlds, so read it with Planet
+	Planets map[int]*common.Planet
+
+	// OnText is called with each chat message
+	OnText func(text string)
+	// OnHit is called when another player hits the bot
+	OnHit func(from string, amount int)
+	// OnCall is called after each call the bot waits on, with how long it took
+	OnCall func(method string, d time.Duration, e error)
+
+	session     *yamux.Session
+	rpc         *rpc.Client
+	timeOffset  float64
+	timeMutex   sync.Mutex
+	following   string
+	people      map[string]common.PlayerState
+	peopleMutex sync.Mutex
+	mutex       sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// Connect logs a bot into a server, loads the planets and spawns it
+func Connect(conn net.Conn, name, password string) (*Bot, error) {
+	b := &Bot{
+		Player:  common.NewPlayer(name),
+		Planets: make(map[int]*common.Planet),
+		people:  make(map[string]common.PlayerState),
+		done:    make(chan struct{}),
+	}
+	config := yamux.DefaultConfig()
+	config.ConnectionWriteTimeout = Timeout
+	session, e := yamux.Client(conn, config)
+	if e != nil {
+		conn.Close()
+		return nil, e
+	}
+	b.session = session
+	stream, e := session.Open()
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+	b.rpc = rpc.NewClient(stream)
+
+	var reply common.LoginReply
+	e = b.call("API.Login", &common.LoginArgs{Name: name, Password: password}, &reply)
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+
+	// Serve the calls the server makes back to us
+	smuxConn, e := session.Accept()
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+	s := rpc.NewServer()
+	s.Register(&API{bot: b})
+	go s.ServeConn(smuxConn)
+
+	e = b.syncClock()
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+	planetStates := []*common.PlanetState{}
+	e = b.call("API.GetPlanetStates", 0, &planetStates)
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+	planets := make(map[int]*common.Planet)
+	for _, state := range planetStates {
+		planets[state.ID] = common.NewPlanet(*state, b.rpc, nil)
+	}
+	if planets[0] == nil {
+		session.Close()
+		return nil, errors.New("server has no home planet")
+	}
+	b.mutex.Lock()
+	b.Planets = planets
+	b.Player.Planet = planets[0]
+	e = b.Player.Spawn()
+	for _, planet := range planets {
+		planet.Streaming = true
+	}
+	b.mutex.Unlock()
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+	var streaming bool
+	e = b.call("API.StartChunkStream", b.Player.RenderDistance(), &streaming)
+	if e != nil {
+		session.Close()
+		return nil, e
+	}
+
+	go b.run()
+	return b, nil
+}
+
+// Dial connects to a server by host and port and logs a bot in. Hosts starting
+// with tls:// are dialed with TLS, pinning the certificate in KnownHosts.
+func Dial(host string, port int, name, password string) (*Bot, error) {
+	conn, e := common.DialServer(host, port, KnownHosts)
+	if e != nil {
+		return nil, e
+	}
+	return Connect(conn, name, password)
+}
+
+// Close leaves the game and closes the connection
+func (b *Bot) Close() {
+	b.closeOnce.Do(func() {
+		var ret bool
+		b.call("API.Leave", 0, &ret)
+		close(b.done)
+		b.session.Close()
+	})
+}
+
+// Planet returns one of the planets of the bot's world by ID, or nil
+func (b *Bot) Planet(id int) *common.Planet {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Planets[id]
+}
+
+// Done is closed once the bot is disconnected
+func (b *Bot) Done() <-chan struct{} {
+	return b.done
+}
+
+// Time returns the server's universe clock
+func (b *Bot) Time() float64 {
+	b.timeMutex.Lock()
+	defer b.timeMutex.Unlock()
+	return common.Now() + b.timeOffset
+}
+
+// Call calls a server API method, giving up after Timeout
+func (b *Bot) Call(method string, args interface{}, reply interface{}) error {
+	return b.call(method, args, reply)
+}
+
+func (b *Bot) call(method string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	call := b.rpc.Go(method, args, reply, make(chan *rpc.Call, 1))
+	var e error
+	select {
+	case <-call.Done:
+		e = call.Error
+	case <-time.After(Timeout):
+		e = errTimeout
+	}
+	if b.OnCall != nil {
+		b.OnCall(method, time.Since(start), e)
+	}
+	return e
+}
+
+// syncClock estimates the offset to the server clock from the fastest of a few round trips
+func (b *Bot) syncClock() error {
+	bestRoundTrip := math.Inf(1)
+	offset := 0.0
+	for i := 0; i < clockSamples; i++ {
+		var serverTime float64
+		t0 := common.Now()
+		e := b.call("API.GetTime", 0, &serverTime)
+		t1 := common.Now()
+		if e != nil {
+			return e
+		}
+		if t1-t0 < bestRoundTrip {
+			bestRoundTrip = t1 - t0
+			offset = serverTime - (t0+t1)/2
+		}
+	}
+	b.timeMutex.Lock()
+	b.timeOffset = offset
+	b.timeMutex.Unlock()
+	return nil
+}
+
+// run steps the player physics and reports the bot's state until it disconnects
+func (b *Bot) run() {
+	ticker := time.NewTicker(time.Second / tickRate)
+	defer ticker.Stop()
+	t := time.Now()
+	syncT := t
+	b.mutex.Lock()
+	syncLoc := b.Player.Location()
+	b.mutex.Unlock()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+		if b.session.IsClosed() {
+			b.closeOnce.Do(func() { close(b.done) })
+			return
+		}
+		h := float32(time.Since(t)) / float32(time.Second)
+		t = time.Now()
+
+		b.mutex.Lock()
+		b.Player.UpdatePosition(h)
+		b.followPlayer()
+		var state *common.PlayerState
+		if time.Since(syncT) >= updateInterval {
+			vel := b.Player.Location().Sub(syncLoc).Mul(float32(time.Second) / float32(time.Since(syncT)))
+			syncT = time.Now()
+			syncLoc = b.Player.Location()
+			state = &common.PlayerState{
+				Name:     b.Player.Name,
+				Planet:   b.Player.Planet.ID,
+				Position: b.Player.Location(),
+				Velocity: vel,
+				LookDir:  b.Player.LookDir(),
+				GameMode: b.Player.GameMode,
+				Time:     b.Time(),
+			}
+		}
+		b.mutex.Unlock()
+
+		if state != nil {
+			var ret bool
+			b.rpc.Go("API.UpdatePersonState", state, &ret, nil)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"errors"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// API is the RPC tag for calls from the server to a bot
+type API struct {
+	bot *Bot
+}
+
+// SetCellMaterial sets the material for a particular cell
+func (api *API) SetCellMaterial(args *common.RPCSetCellMaterialArgs, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	planet := b.Planets[args.Planet]
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	planet.SetCellMaterial(args.Index, args.Material, false)
+	*ret = true
+	return nil
+}
+
+// SetCellMaterials sets the materials for many cells at once
+func (api *API) SetCellMaterials(args *common.RPCSetCellMaterialsArgs, ret *bool) error {
+	byPlanet := make(map[int][]common.RPCSetCellMaterialArgs)
+	for _, edit := range args.Edits {
+		byPlanet[edit.Planet] = append(byPlanet[edit.Planet], edit)
+	}
+	b := api.bot
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for id, edits := range byPlanet {
+		planet := b.Planets[id]
+		if planet == nil {
+			return errors.New("Unknown planet ID")
+		}
+		planet.SetCellMaterials(edits, false)
+	}
+	*ret = true
+	return nil
+}
+
+// ReceiveChunk stores a chunk streamed from the server
+func (api *API) ReceiveChunk(args *common.ChunkData, ret *bool) error {
+	planet := api.bot.Planet(args.Planet)
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	chunk, e := common.DecodeChunk(args.Data)
+	if e != nil {
+		return e
+	}
+	planet.ReceiveChunk(args.ChunkIndex, chunk)
+	*ret = true
+	return nil
+}
+
+// Ping lets the server check that this bot is still there
+func (api *API) Ping(args *int, ret *bool) error {
+	*ret = true
+	return nil
+}
+
+// PersonDisconnected forgets a player who left
+func (api *API) PersonDisconnected(name *string, ret *bool) error {
+	b := api.bot
+	b.peopleMutex.Lock()
+	_, *ret = b.people[*name]
+	delete(b.people, *name)
+	b.peopleMutex.Unlock()
+	return nil
+}
+
+// UpdatePersonState records another player's state
+func (api *API) UpdatePersonState(state *common.PlayerState, ret *bool) error {
+	b := api.bot
+	if state.Name == b.Player.Name {
+		return nil
+	}
+	b.peopleMutex.Lock()
+	b.people[state.Name] = *state
+	b.peopleMutex.Unlock()
+	return nil
+}
+
+// UpdatePersonSummary updates where a far player is, keeping the rest of their last known state
+func (api *API) UpdatePersonSummary(summary *common.PlayerSummary, ret *bool) error {
+	b := api.bot
+	if summary.Name == b.Player.Name {
+		return nil
+	}
+	b.peopleMutex.Lock()
+	state := b.people[summary.Name]
+	state.Name = summary.Name
+	state.Planet = summary.Planet
+	state.Position = summary.Position
+	state.Velocity = mgl32.Vec3{}
+	state.Time = summary.Time
+	b.people[summary.Name] = state
+	b.peopleMutex.Unlock()
+	return nil
+}
+
+// CorrectPosition moves the bot back to where the server last accepted it
+func (api *API) CorrectPosition(pos *mgl32.Vec3, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	b.Player.SetLocation(*pos)
+	b.Player.FallVel = 0
+	b.mutex.Unlock()
+	*ret = true
+	return nil
+}
+
+// Teleport moves the bot to a position, possibly on another planet
+func (api *API) Teleport(args *common.TeleportArgs, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	planet := b.Planets[args.Planet]
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	b.Player.Planet = planet
+	b.Player.SetLocation(args.Position)
+	b.Player.FallVel = 0
+	*ret = true
+	return nil
+}
+
+// SetPlanet moves the bot to the spawn point of a planet
+func (api *API) SetPlanet(id *int, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	planet := b.Planets[*id]
+	if planet == nil {
+		return errors.New("Unknown planet ID")
+	}
+	b.Player.Planet = planet
+	if e := b.Player.Spawn(); e != nil {
+		return e
+	}
+	*ret = true
+	return nil
+}
+
+// SetWorld replaces the planets with another world's and spawns the bot on its home planet
+func (api *API) SetWorld(args *common.WorldArgs, ret *bool) error {
+	b := api.bot
+	planets := make(map[int]*common.Planet)
+	for _, state := range args.Planets {
+		planet := common.NewPlanet(*state, b.rpc, nil)
+		planet.Streaming = true
+		planets[planet.ID] = planet
+	}
+	if planets[0] == nil {
+		return errors.New("world has no home planet")
+	}
+	b.mutex.Lock()
+	b.Planets = planets
+	b.Player.Planet = planets[0]
+	e := b.Player.Spawn()
+	b.mutex.Unlock()
+	if e != nil {
+		return e
+	}
+	b.peopleMutex.Lock()
+	b.people = make(map[string]common.PlayerState)
+	b.peopleMutex.Unlock()
+	go b.syncClock()
+	*ret = true
+	return nil
+}
+
+// Follow attaches a spectating bot to another player, or detaches it if name is empty
+func (api *API) Follow(name *string, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	b.following = *name
+	b.mutex.Unlock()
+	*ret = true
+	return nil
+}
+
+// SetGameMode changes the bot's game mode
+func (api *API) SetGameMode(mode *int, ret *bool) error {
+	api.bot.SetGameMode(*mode)
+	*ret = true
+	return nil
+}
+
+// Give puts a material in the bot's selected hotbar slot
+func (api *API) Give(material *int, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	b.Player.Hotbar[b.Player.ActiveHotBarSlot] = *material
+	b.mutex.Unlock()
+	*ret = true
+	return nil
+}
+
+// SendText delivers a chat message
+func (api *API) SendText(text *string, ret *bool) error {
+	if api.bot.OnText != nil {
+		api.bot.OnText(*text)
+	}
+	*ret = true
+	return nil
+}
+
+// HitPlayer damages the bot
+func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
+	b := api.bot
+	b.mutex.Lock()
+	e := b.Player.UpdateHealth(-args.Amount)
+	b.mutex.Unlock()
+	if e != nil {
+		return e
+	}
+	if b.OnHit != nil {
+		b.OnHit(args.From, args.Amount)
+	}
+	*ret = true
+	return nil
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"math"
+	"net/rpc"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Clock sync settings
+const (
+	clockSamples      = 8
+	clockSyncInterval = 30 * time.Second
+)
+
+// syncClock estimates the offset from local time to the server's universe clock,
+// NTP style, trusting the sample with the shortest round trip the most
+func syncClock(crpc *rpc.Client) error {
+	bestRoundTrip := math.Inf(1)
+	offset := 0.0
+	for i := 0; i < clockSamples; i++ {
+		var serverTime float64
+		t0 := common.Now()
+		e := callTimeout(crpc, "API.GetTime", 0, &serverTime)
+		t1 := common.Now()
+		if e != nil {
+			return e
+		}
+		if t1-t0 < bestRoundTrip {
+			bestRoundTrip = t1 - t0
+			offset = serverTime - (t0+t1)/2
+		}
+	}
+	universe.SetTimeOffset(offset)
+	return nil
+}
+
+// keepClockSynced resyncs the clock now and then to follow drift between the machines
+func keepClockSynced() {
+	for !isLeaving() {
+		time.Sleep(clockSyncInterval)
+		if universe.Status() == "" {
+			syncClock(universe.RPC())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// knownHostsFile pins the certificates of TLS servers the first time they are seen
+const knownHostsFile = common.KnownHostsFile
+
+// Reconnect backoff limits
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// connection is a logged in session with the server
+type connection struct {
+	session *yamux.Session
+	rpc     *rpc.Client
+}
+
+// The reconnect goroutine switches the connection while the render loop and
+// input handlers use it, so conn and leaving are only touched under connMutex
+var (
+	conn      *connection
+	login     *common.LoginArgs
+	leaving   bool
+	connMutex = &sync.Mutex{}
+)
+
+// currentConnection returns the session in use
+func currentConnection() *connection {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	return conn
+}
+
+// setConnection switches to a new session and points the universe at it. It
+// returns false without switching once the player is leaving.
+func setConnection(c *connection) bool {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	if leaving {
+		return false
+	}
+	conn = c
+	universe.SetRPC(c.rpc)
+	return true
+}
+
+// leave stops reconnecting and returns the session to close
+func leave() *connection {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	leaving = true
+	return conn
+}
+
+// isLeaving returns whether the player is leaving the server
+func isLeaving() bool {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	return leaving
+}
+
+// connect dials the server, logs in, and starts serving the client API.
+// On success the login args are switched to the token the server returned.
+func connect(host string, port int, login *common.LoginArgs) (*connection, error) {
+	conn, e := common.DialServer(host, port, knownHostsFile)
+	if e != nil {
+		return nil, e
+	}
+
+	// Setup client side of yamux
+	config := yamux.DefaultConfig()
+	config.KeepAliveInterval = HeartbeatInterval
+	config.ConnectionWriteTimeout = HeartbeatTimeout
+	cmux, e := yamux.Client(conn, config)
+	if e != nil {
+		conn.Close()
+		return nil, e
+	}
+	stream, e := cmux.Open()
+	if e != nil {
+		cmux.Close()
+		return nil, e
+	}
+	cRPC := rpc.NewClient(stream)
+
+	var reply common.LoginReply
+	e = callTimeout(cRPC, "API.Login", login, &reply)
+	if e != nil {
+		cmux.Close()
+		return nil, e
+	}
+	login.Password = ""
+	login.Token = reply.Token
+
+	// Setup server connection
+	smuxConn, e := cmux.Accept()
+	if e != nil {
+		cmux.Close()
+		return nil, e
+	}
+	s := rpc.NewServer()
+	clientAPI := new(API)
+	s.Register(clientAPI)
+	go s.ServeConn(smuxConn)
+
+	return &connection{session: cmux, rpc: cRPC}, nil
+}
+
+// startStreaming asks the server to push chunks near the player
+func (c *connection) startStreaming() error {
+	for _, planetRen := range universe.PlanetMap {
+		planetRen.Planet.Streaming = true
+	}
+	var streaming bool
+	return callTimeout(c.rpc, "API.StartChunkStream", universe.Player.RenderDistance(), &streaming)
+}
+
+// resync points the planets at a new connection and refreshes what may have
+// changed while disconnected. The local player is left where they were, which
+// the server keeps from their last session.
+func (c *connection) resync() error {
+	planetStates := []*common.PlanetState{}
+	e := callTimeout(c.rpc, "API.GetPlanetStates", 0, &planetStates)
+	if e != nil {
+		return e
+	}
+	e = syncClock(c.rpc)
+	if e != nil {
+		return e
+	}
+	for _, state := range planetStates {
+		planetRen := universe.PlanetMap[state.ID]
+		if planetRen == nil {
+			log.Printf("Server has new planet %v, it will appear after restarting", state.Name)
+			continue
+		}
+		planetRen.Planet.SetRPC(c.rpc)
+	}
+	universe.ConnectedPeople = nil
+	universe.RemoveSnapshots("")
+
+	// The server streams all nearby chunks again on a new session, replacing stale ones
+	return c.startStreaming()
+}
+
+// stayConnected watches the connection and reconnects with backoff whenever it is lost
+func stayConnected(host string, port int, login *common.LoginArgs) {
+	for {
+		current := currentConnection()
+		heartbeat(current.rpc, current.session)
+		if isLeaving() {
+			return
+		}
+		universe.SetStatus("Reconnecting...")
+		delay := minReconnectDelay
+		for {
+			time.Sleep(delay)
+			next, e := connect(host, port, login)
+			if e == nil {
+				e = next.resync()
+				if e == nil {
+					if setConnection(next) {
+						break
+					}
+					next.session.Close()
+					return
+				}
+				next.session.Close()
+			}
+			log.Println("Reconnect failed:", e)
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+		log.Println("Reconnected to server")
+		universe.SetStatus("")
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// DiscoverServers listens for servers announcing themselves on the local network
+func DiscoverServers(wait time.Duration) ([]common.ServerAnnouncement, error) {
+	conn, e := net.ListenMulticastUDP("udp4", nil, common.DiscoveryAddress)
+	if e != nil {
+		return nil, e
+	}
+	defer conn.Close()
+	found := make(map[string]common.ServerAnnouncement)
+	deadline := time.Now().Add(wait)
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 1500)
+	for time.Now().Before(deadline) {
+		n, addr, e := conn.ReadFromUDP(buf)
+		if e != nil {
+			break
+		}
+		a, e := common.DecodeAnnouncement(buf[:n])
+		if e != nil {
+			continue
+		}
+		a.Host = addr.IP.String()
+		found[fmt.Sprintf("%v:%v", a.Host, a.Port)] = a
+	}
+	servers := []common.ServerAnnouncement{}
+	for _, a := range found {
+		servers = append(servers, a)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+		return servers[i].Host < servers[j].Host
+	})
+	return servers, nil
+}
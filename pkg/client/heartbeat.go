@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Heartbeat settings for the connection to the server
+var (
+	HeartbeatInterval = 5 * time.Second
+	HeartbeatTimeout  = 15 * time.Second
+)
+
+var errTimeout = errors.New("call timed out")
+
+// callTimeout calls a server API method, giving up after the heartbeat timeout
+func callTimeout(crpc *rpc.Client, method string, args interface{}, reply interface{}) error {
+	call := crpc.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(HeartbeatTimeout):
+		return errTimeout
+	}
+}
+
+// heartbeat pings the server until it stops answering, then closes the session
+func heartbeat(crpc *rpc.Client, session *yamux.Session) {
+	for !session.IsClosed() {
+		time.Sleep(HeartbeatInterval)
+		var ret bool
+		e := callTimeout(crpc, "API.Ping", 0, &ret)
+		if e != nil {
+			log.Println("Lost connection to server:", e)
+			session.Close()
+			return
+		}
+	}
+}
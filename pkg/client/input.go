@@ -46,7 +46,7 @@ func glToPixel(w *glfw.Window, xpos, ypos float64) (xpix, ypix float64) {
 // 		universe.Player.Intext = false
 // 		// NewText()
 // 		var ret bool
-// 		universe.RPC.Go("API.SendText", universe.Player.Text, &ret, nil)
+// 		universe.RPC().Go("API.SendText", universe.Player.Text, &ret, nil)
 // 		universe.Player.Text = ""
 // 		// need to send text to server
 // 	} else if text == "delete" {
@@ -144,8 +144,12 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 		case m["Left"].Key:
 			player.LeftVel = player.WalkVel
 		case m["Mode"].Key:
+			// Spectator mode is entered and left through the server
+			if player.GameMode == common.Spectator {
+				break
+			}
 			player.GameMode++
-			if player.GameMode >= common.NumGameModes {
+			if player.GameMode >= common.Spectator {
 				player.GameMode = 0
 			}
 			if player.GameMode == common.Flying {
@@ -184,17 +188,25 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 				}
 			}
 		case m["Down"].Key:
-			if cursorGrabbed(w) && player.GameMode == common.Flying {
+			if cursorGrabbed(w) && player.GameMode != common.Normal {
 				player.DownVel = player.WalkVel
 			}
 		case m["PlanetR"].Key:
+			if universe.Status() != "" {
+				// Spawning loads chunks synchronously, which needs the server
+				break
+			}
 			id := player.Planet.ID + 1
 			if universe.PlanetMap[id] == nil {
 				id = 0
 			}
-			player.Planet = universe.PlanetMap[id].Planet
-			player.Spawn()
+			// The server moves the player there, so it knows the jump is allowed
+			var ret bool
+			universe.RPC().Go("API.ChangePlanet", id, &ret, nil)
 		case m["PlanetL"].Key:
+			if universe.Status() != "" {
+				break
+			}
 			id := player.Planet.ID - 1
 			if universe.PlanetMap[id] == nil {
 				if id < 0 {
@@ -205,9 +217,12 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 					}
 				}
 			}
-			player.Planet = universe.PlanetMap[id].Planet
-			player.Spawn()
+			var ret bool
+			universe.RPC().Go("API.ChangePlanet", id, &ret, nil)
 		case m["Destroy"].Key:
+			if player.GameMode == common.Spectator {
+				break
+			}
 			increment := player.LookDir().Mul(0.05)
 			pos := player.Location()
 			for i := 0; i < 100; i++ {
@@ -218,7 +233,7 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 					if pos.Sub(otherPlayer.Position).Len() < 0.6 {
 						log.Println(fmt.Sprintf("Hit %v", otherPlayer.Name))
 						var ret bool
-						universe.RPC.Go("API.HitPlayer", common.HitPlayerArgs{From: player.Name, Target: otherPlayer.Name, Amount: 1}, &ret, nil)
+						universe.RPC().Go("API.HitPlayer", common.HitPlayerArgs{From: player.Name, Target: otherPlayer.Name, Amount: 1}, &ret, nil)
 						hitPlayer = true
 						break
 					}
@@ -233,6 +248,9 @@ func keyCallbackPlay(w *glfw.Window, key glfw.Key, scancode int, action glfw.Act
 				}
 			}
 		case m["Build"].Key:
+			if player.GameMode == common.Spectator {
+				break
+			}
 			increment := player.LookDir().Mul(0.05)
 			pos := player.Location()
 			prevCellIndex := common.CellIndex{Lon: -1, Lat: -1, Alt: -1}
@@ -330,7 +348,7 @@ func mouseButtonCallback(w *glfw.Window, button glfw.MouseButton, action glfw.Ac
 // 					if pos.Sub(otherPlayer.Position).Len() < 0.6 {
 // 						log.Println(fmt.Sprintf("Hit %v", otherPlayer.Name))
 // 						var ret bool
-// 						universe.RPC.Go("API.HitPlayer", common.HitPlayerArgs{From: player.Name, Target: otherPlayer.Name, Amount: 1}, &ret, nil)
+// 						universe.RPC().Go("API.HitPlayer", common.HitPlayerArgs{From: player.Name, Target: otherPlayer.Name, Amount: 1}, &ret, nil)
 // 						hitPlayer = true
 // 						break
 // 					}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
+	"github.com/go-gl/mathgl/mgl32"
 	"github.com/jeffbaumes/buildorb/pkg/common"
 )
 
@@ -12,15 +14,50 @@ type API int
 
 // SetCellMaterial sets the material for a particular cell
 func (api *API) SetCellMaterial(args *common.RPCSetCellMaterialArgs, ret *bool) error {
-	universe.PlanetMap[args.Planet].SetCellMaterial(args.Index, args.Material, false)
+	planetRen := universe.PlanetMap[args.Planet]
+	if planetRen == nil {
+		return errors.New("Unknown planet ID")
+	}
+	planetRen.SetCellMaterial(args.Index, args.Material, false)
+	*ret = true
+	return nil
+}
+
+// SetCellMaterials sets the materials for many cells at once
+func (api *API) SetCellMaterials(args *common.RPCSetCellMaterialsArgs, ret *bool) error {
+	byPlanet := make(map[int][]common.RPCSetCellMaterialArgs)
+	for _, edit := range args.Edits {
+		byPlanet[edit.Planet] = append(byPlanet[edit.Planet], edit)
+	}
+	for id, edits := range byPlanet {
+		planetRen := universe.PlanetMap[id]
+		if planetRen == nil {
+			return errors.New("Unknown planet ID")
+		}
+		planetRen.SetCellMaterials(edits, false)
+	}
 	*ret = true
 	return nil
 }
 
-// GetPersonState returns this client's logged in user state
-func (api *API) GetPersonState(args *int, ret *common.PlayerState) error {
-	ret.Name = universe.Player.Name
-	ret.Position = universe.Player.Location()
+// ReceiveChunk stores a chunk streamed from the server
+func (api *API) ReceiveChunk(args *common.ChunkData, ret *bool) error {
+	planetRen := universe.PlanetMap[args.Planet]
+	if planetRen == nil {
+		return errors.New("Unknown planet ID")
+	}
+	chunk, e := common.DecodeChunk(args.Data)
+	if e != nil {
+		return e
+	}
+	planetRen.ReceiveChunk(args.ChunkIndex, chunk)
+	*ret = true
+	return nil
+}
+
+// Ping lets the server check that this client is still there
+func (api *API) Ping(args *int, ret *bool) error {
+	*ret = true
 	return nil
 }
 
@@ -35,6 +72,10 @@ func (api *API) PersonDisconnected(name *string, ret *bool) error {
 		}
 	}
 	universe.ConnectedPeople = validPeople
+	universe.RemoveSnapshots(*name)
+	if universe.Following == *name {
+		universe.Following = ""
+	}
 	return nil
 }
 
@@ -43,11 +84,11 @@ func (api *API) UpdatePersonState(state *common.PlayerState, ret *bool) error {
 	if state.Name == universe.Player.Name {
 		return nil
 	}
+	universe.AddSnapshot(state, universe.Time())
 	found := false
 	for _, c := range universe.ConnectedPeople {
 		if c.Name == state.Name {
-			c.Position = state.Position
-			c.LookDir = state.LookDir
+			*c = *state
 			found = true
 			break
 		}
@@ -58,6 +99,96 @@ func (api *API) UpdatePersonState(state *common.PlayerState, ret *bool) error {
 	return nil
 }
 
+// UpdatePersonSummary updates where a far player is. The rest of their last
+// known state is kept, but they are no longer moving.
+func (api *API) UpdatePersonSummary(summary *common.PlayerSummary, ret *bool) error {
+	if summary.Name == universe.Player.Name {
+		return nil
+	}
+	state := &common.PlayerState{Name: summary.Name}
+	for _, c := range universe.ConnectedPeople {
+		if c.Name == summary.Name {
+			state = c
+			break
+		}
+	}
+	update := *state
+	update.Planet = summary.Planet
+	update.Position = summary.Position
+	update.Velocity = mgl32.Vec3{}
+	update.Time = summary.Time
+	return api.UpdatePersonState(&update, ret)
+}
+
+// CorrectPosition moves the player back to where the server last accepted them
+func (api *API) CorrectPosition(pos *mgl32.Vec3, ret *bool) error {
+	universe.Player.SetLocation(*pos)
+	universe.Player.FallVel = 0
+	*ret = true
+	return nil
+}
+
+// Teleport moves the player to a position, possibly on another planet
+func (api *API) Teleport(args *common.TeleportArgs, ret *bool) error {
+	planetRen := universe.PlanetMap[args.Planet]
+	if planetRen == nil {
+		return errors.New("Unknown planet ID")
+	}
+	universe.Player.Planet = planetRen.Planet
+	universe.Player.SetLocation(args.Position)
+	universe.Player.FallVel = 0
+	*ret = true
+	return nil
+}
+
+// SetPlanet moves the player to the spawn point of a planet
+func (api *API) SetPlanet(id *int, ret *bool) error {
+	planetRen := universe.PlanetMap[*id]
+	if planetRen == nil {
+		return errors.New("Unknown planet ID")
+	}
+	universe.Player.Planet = planetRen.Planet
+	if e := universe.Player.Spawn(); e != nil {
+		return e
+	}
+	*ret = true
+	return nil
+}
+
+// SetWorld moves the player to another world, returning once the planets have been replaced
+func (api *API) SetWorld(args *common.WorldArgs, ret *bool) error {
+	done := make(chan struct{})
+	worldChanges <- worldChange{args, done}
+	<-done
+	*ret = true
+	return nil
+}
+
+// SetGameMode changes the player's game mode
+func (api *API) SetGameMode(mode *int, ret *bool) error {
+	universe.Player.GameMode = *mode
+	if *mode != common.Spectator {
+		universe.Following = ""
+	}
+	*ret = true
+	return nil
+}
+
+// Follow attaches a spectator's view to another player, or detaches it if name is empty
+func (api *API) Follow(name *string, ret *bool) error {
+	universe.Following = *name
+	*ret = true
+	return nil
+}
+
+// Give puts a material in the player's selected hotbar slot
+func (api *API) Give(material *int, ret *bool) error {
+	player := universe.Player
+	player.Hotbar[player.ActiveHotBarSlot] = *material
+	*ret = true
+	return nil
+}
+
 // SendText sends a player text
 func (api *API) SendText(text *string, ret *bool) error {
 	universe.Player.DrawText = *text
@@ -68,7 +199,9 @@ func (api *API) SendText(text *string, ret *bool) error {
 // HitPlayer damages a playerv
 func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
 	log.Println(fmt.Sprintf("Hit by %v", args.From))
-	universe.Player.UpdateHealth(-args.Amount)
+	if e := universe.Player.UpdateHealth(-args.Amount); e != nil {
+		return e
+	}
 	*ret = true
 	return nil
 }
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// followPlayer moves a spectator to the smoothed view of the player they follow
+func followPlayer(player *common.Player) {
+	if universe.Following == "" || player.GameMode != common.Spectator {
+		return
+	}
+	state, ok := universe.PlayerSnapshot(universe.Following, universe.Time())
+	if !ok {
+		return
+	}
+	planetRen := universe.PlanetMap[state.Planet]
+	if planetRen == nil {
+		return
+	}
+	player.Planet = planetRen.Planet
+	player.SetLocation(state.Position)
+	player.SetLookDir(state.LookDir)
+}
@@ -1,15 +1,11 @@
 package client
 
 import (
-	"fmt"
-	"net"
-	"net/rpc"
 	"runtime"
 	"time"
 
 	"github.com/anbcodes/goguigl/gui"
 	"github.com/go-gl/glfw/v3.2/glfw"
-	"github.com/hashicorp/yamux"
 	"github.com/jeffbaumes/buildorb/pkg/common"
 	"github.com/jeffbaumes/buildorb/pkg/scene"
 )
@@ -25,8 +21,9 @@ var (
 	op       *scene.Options
 )
 
-// Start starts a client with the given username, host, and port
-func Start(username, host string, port int, scr *gui.Screen) {
+// Start starts a client with the given username, password, host, and port. The
+// player joins the named world, or the server's main world if it is empty.
+func Start(username, password, host string, port int, world string, scr *gui.Screen) {
 	screen = scr
 	screen.Clear()
 	if host == "" {
@@ -44,56 +41,54 @@ func Start(username, host string, port int, scr *gui.Screen) {
 	}
 
 	defer glfw.Terminate()
-	conn, err := net.Dial("tcp", fmt.Sprintf("%v:%v", host, port))
-	if err != nil {
-		panic(err)
-	}
 
-	// Setup client side of yamux
-	cmux, e := yamux.Client(conn, nil)
+	player := common.NewPlayer(username)
+	universe = scene.NewUniverse(player, nil)
+
+	login = &common.LoginArgs{Name: username, Password: password, World: world}
+	first, e := connect(host, port, login)
 	if e != nil {
 		panic(e)
 	}
-	stream, e := cmux.Open()
+	setConnection(first)
+	e = syncClock(first.rpc)
 	if e != nil {
 		panic(e)
 	}
-	cRPC := rpc.NewClient(stream)
-
-	player := common.NewPlayer(username)
-	universe = scene.NewUniverse(player, cRPC)
 
 	planetStates := []*common.PlanetState{}
-	e = cRPC.Call("API.GetPlanetStates", 0, &planetStates)
+	e = first.rpc.Call("API.GetPlanetStates", 0, &planetStates)
 	if e != nil {
 		panic(e)
 	}
 	for _, state := range planetStates {
-		planet := common.NewPlanet(*state, cRPC, nil)
+		planet := common.NewPlanet(*state, first.rpc, nil)
 		planetRen := scene.NewPlanet(planet)
 		universe.AddPlanet(planetRen)
 	}
 
 	op = scene.NewOptions(screen)
 	player.Planet = universe.PlanetMap[0].Planet
-	player.Spawn()
+	e = player.Spawn()
+	if e != nil {
+		panic(e)
+	}
 
 	over := scene.NewCrosshair()
 	text := &scene.Text{}
 	bar := scene.NewHotbar()
 	health := scene.NewHealth()
 	player.Mode = "Play"
-	// Setup server connection
-	smuxConn, e := cmux.Accept()
+
+	// Let the server push chunks near the player from now on
+	e = first.startStreaming()
 	if e != nil {
 		panic(e)
 	}
-	s := rpc.NewServer()
-	clientAPI := new(API)
-	s.Register(clientAPI)
-	go s.ServeConn(smuxConn)
+	go stayConnected(host, port, login)
+	go keepClockSynced()
 
-	peopleRen := scene.NewPlayers(&universe.ConnectedPeople)
+	peopleRen := scene.NewPlayers(universe)
 	focusRen := scene.NewFocusCell()
 
 	window.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
@@ -102,27 +97,41 @@ func Start(username, host string, port int, scr *gui.Screen) {
 	window.SetSizeCallback(windowSizeCallback)
 	window.SetMouseButtonCallback(mouseButtonCallback)
 
-	startTime := time.Now()
-	t := startTime
+	t := time.Now()
 	syncT := t
+	syncLoc := player.Location()
 	for !window.ShouldClose() {
+		applyWorldChange()
 		h := float32(time.Since(t)) / float32(time.Second)
 		t = time.Now()
-		elapsedSeconds := float64(time.Since(startTime)) / float64(time.Second)
+		elapsedSeconds := universe.Time()
 
 		drawFrame(h, player, text, over, peopleRen, focusRen, bar, health, screen, elapsedSeconds, op)
 
 		player.UpdatePosition(h)
+		followPlayer(player)
 
 		if float64(time.Since(syncT))/float64(time.Second) > 0.05 {
+			vel := player.Location().Sub(syncLoc).Mul(float32(time.Second) / float32(time.Since(syncT)))
 			syncT = time.Now()
+			syncLoc = player.Location()
 			var ret bool
-			cRPC.Go("API.UpdatePersonState", &common.PlayerState{
+			universe.RPC().Go("API.UpdatePersonState", &common.PlayerState{
 				Name:     player.Name,
+				Planet:   player.Planet.ID,
 				Position: player.Location(),
+				Velocity: vel,
 				LookDir:  player.LookDir(),
+				GameMode: player.GameMode,
+				Time:     universe.Time(),
 			}, &ret, nil)
 		}
 		time.Sleep(time.Second/time.Duration(targetFPS) - time.Since(t))
 	}
+
+	// Tell the server we are leaving rather than letting it time out
+	last := leave()
+	var ret bool
+	callTimeout(last.rpc, "API.Leave", 0, &ret)
+	last.session.Close()
 }
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"log"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+	"github.com/jeffbaumes/buildorb/pkg/scene"
+)
+
+// worldChange is a request from the server to move to another world
+type worldChange struct {
+	args *common.WorldArgs
+	done chan struct{}
+}
+
+var worldChanges = make(chan worldChange, 1)
+
+// applyWorldChange switches worlds if the server asked to. Planet renderers
+// need the OpenGL context, so this must run on the render thread.
+func applyWorldChange() {
+	select {
+	case change := <-worldChanges:
+		switchWorld(change.args)
+		close(change.done)
+	default:
+	}
+}
+
+// switchWorld replaces the planets with another world's and spawns the player on its home planet
+func switchWorld(args *common.WorldArgs) {
+	planetMap := make(map[int]*scene.Planet)
+	for _, state := range args.Planets {
+		planet := common.NewPlanet(*state, universe.RPC(), nil)
+		planet.Streaming = true
+		planetMap[planet.ID] = scene.NewPlanet(planet)
+	}
+	if planetMap[0] == nil {
+		log.Printf("World %v has no home planet", args.Name)
+		return
+	}
+	universe.PlanetMap = planetMap
+	universe.ConnectedPeople = nil
+	universe.RemoveSnapshots("")
+	universe.Player.Planet = planetMap[0].Planet
+	if e := universe.Player.Spawn(); e != nil {
+		log.Printf("Could not spawn in world %v: %v", args.Name, e)
+	}
+	if login != nil {
+		login.World = args.Name
+	}
+	log.Printf("Moved to world %v", args.Name)
+
+	// Each world keeps its own clock
+	go func() {
+		if e := syncClock(universe.RPC()); e != nil {
+			log.Println("Clock sync error:", e)
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+)
+
+// DiscoveryAddress is the multicast group servers announce themselves to on the local network
+var DiscoveryAddress = &net.UDPAddr{IP: net.IPv4(239, 255, 66, 79), Port: 5554}
+
+// ServerAnnouncement describes a server on the local network
+type ServerAnnouncement struct {
+	Name  string
+	World string
+	// Worlds lists every world hosted, starting with the main one
+	Worlds  []string
+	Players int
+	Port    int
+	TLS     bool
+	Host    string // Filled in from where the announcement came from
+}
+
+// Address returns the host to connect to, with the TLS prefix if the server uses TLS
+func (a ServerAnnouncement) Address() string {
+	if a.TLS {
+		return TLSPrefix + a.Host
+	}
+	return a.Host
+}
+
+// EncodeAnnouncement serializes an announcement into one packet
+func EncodeAnnouncement(a ServerAnnouncement) ([]byte, error) {
+	var buf bytes.Buffer
+	e := gob.NewEncoder(&buf).Encode(a)
+	return buf.Bytes(), e
+}
+
+// DecodeAnnouncement reads an announcement packet
+func DecodeAnnouncement(data []byte) (ServerAnnouncement, error) {
+	var a ServerAnnouncement
+	e := gob.NewDecoder(bytes.NewReader(data)).Decode(&a)
+	return a, e
+}
@@ -0,0 +1,16 @@
+package common
+
+// LoginArgs are the arguments for the Login API call. Either a password or a
+// token from an earlier login must be given.
+type LoginArgs struct {
+	Name     string
+	Password string
+	Token    string
+	// World is the hosted world to join, or empty for the server's main world
+	World string
+}
+
+// LoginReply is the result of the Login API call
+type LoginReply struct {
+	Token string
+}
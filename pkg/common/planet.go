@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/gob"
+	"log"
 	"math"
 	"net/rpc"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-gl/mathgl/mgl32"
 	opensimplex "github.com/ojrac/opensimplex-go"
@@ -47,6 +50,7 @@ type Planet struct {
 	LatMax        float64
 	LonCells      int
 	LatCells      int
+	Streaming     bool
 	PlanetState
 }
 
@@ -95,16 +99,17 @@ type CellLoc struct {
 	Lon, Lat, Alt float32
 }
 
+// validChunkIndex returns whether a chunk index is inside the planet
+func (p *Planet) validChunkIndex(ind ChunkIndex) bool {
+	cs := ChunkSize
+	return ind.Lon >= 0 && ind.Lon < p.LonCells/cs &&
+		ind.Lat >= 0 && ind.Lat < p.LatCells/cs &&
+		ind.Alt >= 0 && ind.Alt < p.AltCells/cs
+}
+
 // GetChunk retrieves the chunk of a planet from chunk indices, either synchronously or asynchronously
 func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
-	cs := ChunkSize
-	if ind.Lon < 0 || ind.Lon >= p.LonCells/cs {
-		return nil
-	}
-	if ind.Lat < 0 || ind.Lat >= p.LatCells/cs {
-		return nil
-	}
-	if ind.Alt < 0 || ind.Alt >= p.AltCells/cs {
+	if !p.validChunkIndex(ind) {
 		return nil
 	}
 
@@ -116,7 +121,8 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 		return nil
 	}
 	if chunk == nil {
-		if p.rpc == nil {
+		crpc := p.client()
+		if crpc == nil {
 			if p.db != nil {
 				p.databaseMutex.Lock()
 				rows, e := p.db.Query("SELECT data FROM chunk WHERE planet = ? AND lon = ? AND lat = ? AND alt = ?", p.ID, ind.Lon, ind.Lat, ind.Alt)
@@ -138,12 +144,15 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 						panic(e)
 					}
 					chunk = &ch
+					atomic.AddInt64(&ChunkStats.Loaded, 1)
 				}
 				rows.Close()
 				p.databaseMutex.Unlock()
 				if chunk == nil {
 					chunk = newChunk(ind, p)
+					atomic.AddInt64(&ChunkStats.Generated, 1)
 					p.databaseMutex.Lock()
+					start := time.Now()
 					stmt, e := p.db.Prepare("INSERT INTO chunk VALUES (?, ?, ?, ?, ?)")
 					if e != nil {
 						panic(e)
@@ -158,6 +167,7 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 					if e != nil {
 						panic(e)
 					}
+					recordChunkWrite(start)
 					p.databaseMutex.Unlock()
 				}
 				p.ChunksMutex.Lock()
@@ -169,34 +179,99 @@ func (p *Planet) GetChunk(ind ChunkIndex, async bool) *Chunk {
 				p.Chunks[ind] = chunk
 				p.ChunksMutex.Unlock()
 			}
+		} else if async && p.Streaming {
+			// The server pushes nearby chunks, so just wait for it
+			return nil
 		} else {
 			rchunk := Chunk{}
 			pind := PlanetChunkIndex{Planet: p.ID, ChunkIndex: ind}
 			if async {
-				call := p.rpc.Go("API.GetChunk", pind, &rchunk, nil)
+				call := crpc.Go("API.GetChunk", pind, &rchunk, nil)
 				go func() {
 					call = <-call.Done
 					p.ChunksMutex.Lock()
-					p.Chunks[ind] = &rchunk
+					if call.Error != nil {
+						// Drop the placeholder so the chunk is requested again
+						delete(p.Chunks, ind)
+					} else {
+						p.Chunks[ind] = &rchunk
+					}
 					p.ChunksMutex.Unlock()
 				}()
 				p.ChunksMutex.Lock()
 				p.Chunks[ind] = &Chunk{WaitingForData: true}
 				p.ChunksMutex.Unlock()
 			} else {
-				e := p.rpc.Call("API.GetChunk", pind, &rchunk)
+				chunk, e := p.LoadChunk(ind)
 				if e != nil {
-					panic(e)
+					log.Println("GetChunk error:", e)
 				}
-				p.ChunksMutex.Lock()
-				p.Chunks[ind] = &rchunk
-				p.ChunksMutex.Unlock()
+				return chunk
 			}
 		}
 	}
 	return chunk
 }
 
+// LoadChunk gets a chunk synchronously like GetChunk, but returns the error
+// instead of giving up when the server cannot send it
+func (p *Planet) LoadChunk(ind ChunkIndex) (*Chunk, error) {
+	crpc := p.client()
+	if crpc == nil || !p.validChunkIndex(ind) {
+		return p.GetChunk(ind, false), nil
+	}
+	p.ChunksMutex.Lock()
+	chunk := p.Chunks[ind]
+	p.ChunksMutex.Unlock()
+	if chunk != nil && !chunk.WaitingForData {
+		return chunk, nil
+	}
+	rchunk := Chunk{}
+	e := crpc.Call("API.GetChunk", PlanetChunkIndex{Planet: p.ID, ChunkIndex: ind}, &rchunk)
+	if e != nil {
+		return nil, e
+	}
+	p.ChunksMutex.Lock()
+	p.Chunks[ind] = &rchunk
+	p.ChunksMutex.Unlock()
+	return &rchunk, nil
+}
+
+// client returns the server connection the planet loads from, or nil on the server
+func (p *Planet) client() *rpc.Client {
+	p.ChunksMutex.Lock()
+	defer p.ChunksMutex.Unlock()
+	return p.rpc
+}
+
+// SetRPC switches the planet to a new server connection, dropping requests that were lost with the old one
+func (p *Planet) SetRPC(crpc *rpc.Client) {
+	p.ChunksMutex.Lock()
+	p.rpc = crpc
+	for ind, chunk := range p.Chunks {
+		if chunk.WaitingForData {
+			delete(p.Chunks, ind)
+		}
+	}
+	p.ChunksMutex.Unlock()
+	p.GeometryMutex.Lock()
+	reload := p.Geometry != nil && p.Geometry.IsLoading
+	if reload {
+		p.Geometry = nil
+	}
+	p.GeometryMutex.Unlock()
+	if reload {
+		p.GetGeometry(true)
+	}
+}
+
+// ReceiveChunk stores a chunk that was streamed from the server
+func (p *Planet) ReceiveChunk(ind ChunkIndex, chunk *Chunk) {
+	p.ChunksMutex.Lock()
+	p.Chunks[ind] = chunk
+	p.ChunksMutex.Unlock()
+}
+
 // RPCSetCellMaterialArgs contains the arguments for the SetCellMaterial RPC call
 type RPCSetCellMaterialArgs struct {
 	Planet   int
@@ -204,6 +279,12 @@ type RPCSetCellMaterialArgs struct {
 	Material int
 }
 
+// RPCSetCellMaterialsArgs contains the arguments for the SetCellMaterials RPC
+// call, which changes many cells at once
+type RPCSetCellMaterialsArgs struct {
+	Edits []RPCSetCellMaterialArgs
+}
+
 // SetCellMaterial sets the material for a cell
 func (p *Planet) SetCellMaterial(ind CellIndex, material int, updateServer bool) bool {
 	cell := p.CellIndexToCell(ind)
@@ -214,36 +295,95 @@ func (p *Planet) SetCellMaterial(ind CellIndex, material int, updateServer bool)
 		return false
 	}
 	cell.Material = material
-	if p.rpc != nil && updateServer {
+	if crpc := p.client(); crpc != nil && updateServer {
 		var ret bool
-		p.rpc.Go("API.SetCellMaterial", RPCSetCellMaterialArgs{
+		crpc.Go("API.SetCellMaterial", RPCSetCellMaterialArgs{
 			Planet:   p.ID,
 			Index:    ind,
 			Material: material,
 		}, &ret, nil)
 	}
 	if p.db != nil {
-		chunkInd := p.CellIndexToChunkIndex(ind)
-		chunk := p.CellIndexToChunk(ind)
-		p.databaseMutex.Lock()
-		stmt, e := p.db.Prepare("UPDATE chunk SET data = ? WHERE planet = 0 AND lon = ? AND lat = ? AND alt = ?")
-		if e != nil {
-			panic(e)
+		p.saveChunk(p.CellIndexToChunkIndex(ind))
+	}
+
+	return true
+}
+
+// SetCellMaterials sets the materials of many cells of the planet, saving the
+// changed chunks once each in one transaction. It returns the edits that changed a cell.
+func (p *Planet) SetCellMaterials(edits []RPCSetCellMaterialArgs, updateServer bool) []RPCSetCellMaterialArgs {
+	changed := []RPCSetCellMaterialArgs{}
+	chunks := make(map[ChunkIndex]bool)
+	for _, edit := range edits {
+		cell := p.CellIndexToCell(edit.Index)
+		if cell == nil || cell.Material == edit.Material {
+			continue
+		}
+		cell.Material = edit.Material
+		changed = append(changed, edit)
+		chunks[p.CellIndexToChunkIndex(edit.Index)] = true
+	}
+	if crpc := p.client(); crpc != nil && updateServer && len(changed) > 0 {
+		var ret bool
+		crpc.Go("API.SetCellMaterials", RPCSetCellMaterialsArgs{Edits: changed}, &ret, nil)
+	}
+	if p.db != nil {
+		inds := []ChunkIndex{}
+		for ind := range chunks {
+			inds = append(inds, ind)
+		}
+		p.saveChunks(inds)
+	}
+	return changed
+}
+
+// saveChunk writes a loaded chunk back to the database
+func (p *Planet) saveChunk(ind ChunkIndex) {
+	p.saveChunks([]ChunkIndex{ind})
+}
+
+// saveChunks writes loaded chunks back to the database in one transaction
+func (p *Planet) saveChunks(inds []ChunkIndex) {
+	chunks := make(map[ChunkIndex]*Chunk)
+	p.ChunksMutex.Lock()
+	for _, ind := range inds {
+		if chunk := p.Chunks[ind]; chunk != nil {
+			chunks[ind] = chunk
 		}
+	}
+	p.ChunksMutex.Unlock()
+	if len(chunks) == 0 {
+		return
+	}
+	p.databaseMutex.Lock()
+	defer p.databaseMutex.Unlock()
+	tx, e := p.db.Begin()
+	if e != nil {
+		panic(e)
+	}
+	stmt, e := tx.Prepare("UPDATE chunk SET data = ? WHERE planet = ? AND lon = ? AND lat = ? AND alt = ?")
+	if e != nil {
+		panic(e)
+	}
+	for ind, chunk := range chunks {
+		start := time.Now()
 		var buf bytes.Buffer
 		enc := gob.NewEncoder(&buf)
 		e = enc.Encode(chunk)
 		if e != nil {
 			panic(e)
 		}
-		_, e = stmt.Exec(buf.Bytes(), chunkInd.Lon, chunkInd.Lat, chunkInd.Alt)
+		_, e = stmt.Exec(buf.Bytes(), p.ID, ind.Lon, ind.Lat, ind.Alt)
 		if e != nil {
 			panic(e)
 		}
-		p.databaseMutex.Unlock()
+		recordChunkWrite(start)
+	}
+	stmt.Close()
+	if e := tx.Commit(); e != nil {
+		panic(e)
 	}
-
-	return true
 }
 
 func (p *Planet) validateCellLoc(l CellLoc) CellLoc {
@@ -312,6 +452,11 @@ func (p *Planet) CartesianToChunkIndex(cart mgl32.Vec3) ChunkIndex {
 	return p.CellLocToChunkIndex(l)
 }
 
+// SpawnPoint returns where players appear on the planet, above the ground they land on
+func (p *Planet) SpawnPoint() mgl32.Vec3 {
+	return mgl32.Vec3{float32(p.Radius) + 5, 0, 0}
+}
+
 // CartesianToCellIndex converts world coordinates to a cell index
 func (p *Planet) CartesianToCellIndex(cart mgl32.Vec3) CellIndex {
 	return p.CellLocToCellIndex(p.CartesianToCellLoc(cart))
@@ -480,6 +625,11 @@ func (slice stringSlice) pos(value string) int {
 	return -1
 }
 
+// MaterialByName returns the material with a name, or -1 if there is none
+func MaterialByName(name string) int {
+	return Materials.pos(name)
+}
+
 // List of materials
 var (
 	Materials = stringSlice{
@@ -577,12 +727,16 @@ func (p *Planet) GetGeometry(async bool) *PlanetGeometry {
 	if p.Geometry != nil {
 		return p.Geometry
 	}
-	if p.rpc != nil {
+	if crpc := p.client(); crpc != nil {
 		if async {
 			geom := PlanetGeometry{}
-			call := p.rpc.Go("API.GetPlanetGeometry", &p.ID, &geom, nil)
+			call := crpc.Go("API.GetPlanetGeometry", &p.ID, &geom, nil)
 			go func() {
 				call = <-call.Done
+				if call.Error != nil {
+					// Stay loading until SetRPC asks again
+					return
+				}
 				p.GeometryMutex.Lock()
 				p.Geometry = &geom
 				p.GeometryMutex.Unlock()
@@ -593,7 +747,7 @@ func (p *Planet) GetGeometry(async bool) *PlanetGeometry {
 			return p.Geometry
 		}
 		geom := PlanetGeometry{}
-		e := p.rpc.Call("API.GetPlanetGeometry", &p.ID, &geom)
+		e := crpc.Call("API.GetPlanetGeometry", &p.ID, &geom)
 		if e != nil {
 			panic(e)
 		}
@@ -6,10 +6,12 @@ import (
 	"github.com/go-gl/mathgl/mgl32"
 )
 
-// Game modes
+// Game modes. Spectators fly through terrain, cannot edit or hit, and are
+// hidden from other players.
 const (
 	Normal       = iota
 	Flying       = iota
+	Spectator    = iota
 	NumGameModes = iota
 )
 
@@ -77,8 +79,9 @@ func NewPlayer(name string) *Player {
 	return &p
 }
 
-// Spawn the player on their current planet spawn
-func (player *Player) Spawn() {
+// Spawn the player on their current planet spawn. It fails if the chunks
+// there cannot be loaded, leaving the player where they were.
+func (player *Player) Spawn() error {
 	player.lookHeading = mgl32.Vec3{0, 1, 0}
 	player.Health = MaxHealth
 	player.UpVel = 0
@@ -88,11 +91,15 @@ func (player *Player) Spawn() {
 	player.RightVel = 0
 	player.LeftVel = 0
 	player.FallVel = 0
-	loc := mgl32.Vec3{float32(player.Planet.Radius) + 5, 0, 0}
+	loc := player.Planet.SpawnPoint()
+	old := player.loc
 	player.loc = loc
 
 	// Make sure the spawn area is ready (not async)
-	player.LoadNearbyChunks(false)
+	if e := player.LoadNearbyChunks(false); e != nil {
+		player.loc = old
+		return e
+	}
 
 	// Find a non-air place to land
 	c := player.Planet.CartesianToCell(loc)
@@ -102,6 +109,7 @@ func (player *Player) Spawn() {
 	}
 	loc[0] += 5
 	player.loc = loc
+	return nil
 }
 
 // Location returns the location of the player.
@@ -119,15 +127,17 @@ func (player *Player) SetLocation(loc mgl32.Vec3) {
 	}
 }
 
-// UpdateHealth updates a player health by a certain amount
-func (player *Player) UpdateHealth(amount int) {
+// UpdateHealth updates a player health by a certain amount, respawning the
+// player if it runs out
+func (player *Player) UpdateHealth(amount int) error {
 	player.Health += amount
 	if player.Health <= 0 {
-		player.Spawn()
+		return player.Spawn()
 	}
 	if player.Health > MaxHealth {
 		player.Health = MaxHealth
 	}
+	return nil
 }
 
 // LookDir returns the player's look direction
@@ -147,8 +157,26 @@ func (player *Player) Swivel(deltaX float64, deltaY float64) {
 	player.lookAltitude = math.Max(math.Min(player.lookAltitude, 89.9), -89.9)
 }
 
-// LoadNearbyChunks loads the chunks around the player, either synchronously or asynchronously
-func (player *Player) LoadNearbyChunks(async bool) {
+// SetLookDir points the player in the given direction
+func (player *Player) SetLookDir(dir mgl32.Vec3) {
+	up := player.Location().Normalize()
+	dir = dir.Normalize()
+	heading := ProjectToPlane(dir, up)
+	if heading.Len() > 0 {
+		player.lookHeading = heading.Normalize()
+	}
+	altitude := math.Asin(math.Max(math.Min(float64(dir.Dot(up)), 1), -1)) * 180.0 / math.Pi
+	player.lookAltitude = math.Max(math.Min(altitude, 89.9), -89.9)
+}
+
+// RenderDistance returns how many chunks around the player are loaded
+func (player *Player) RenderDistance() int {
+	return player.renderDistance
+}
+
+// LoadNearbyChunks loads the chunks around the player, either synchronously or
+// asynchronously. Only synchronous loads can fail.
+func (player *Player) LoadNearbyChunks(async bool) error {
 	planet := player.Planet
 	up := player.Location().Normalize()
 	feet := player.Location().Sub(up.Mul(float32(player.height)))
@@ -165,10 +193,16 @@ func (player *Player) LoadNearbyChunks(async bool) {
 		latMax := Min(ind.Lat+player.renderDistance, planet.LatCells/ChunkSize-1)
 		for lat := latMin; lat <= latMax; lat++ {
 			for alt := 0; alt < planet.AltCells/ChunkSize; alt++ {
-				planet.GetChunk(ChunkIndex{Lon: validLon, Lat: lat, Alt: alt}, async)
+				ind := ChunkIndex{Lon: validLon, Lat: lat, Alt: alt}
+				if async {
+					planet.GetChunk(ind, true)
+				} else if _, e := planet.LoadChunk(ind); e != nil {
+					return e
+				}
 			}
 		}
 	}
+	return nil
 }
 
 // UpdatePosition updates the player position
@@ -208,7 +242,7 @@ func (player *Player) UpdatePosition(h float32) {
 			player.collide(planet, float32(height), CellLoc{Lon: 0, Lat: 1, Alt: 0})
 			player.collide(planet, float32(height), CellLoc{Lon: 0, Lat: -1, Alt: 0})
 		}
-	} else if player.GameMode == Flying {
+	} else if player.GameMode == Flying || player.GameMode == Spectator {
 		LookDir := player.LookDir()
 		player.SetLocation(player.Location().Add(up.Mul((player.UpVel - player.DownVel) * h)))
 		player.SetLocation(player.Location().Add(LookDir.Mul((player.ForwardVel - player.BackVel) * h)))
@@ -2,10 +2,34 @@ package common
 
 import "github.com/go-gl/mathgl/mgl32"
 
-// PlayerState holds the state of a person
+// PlayerState holds the state of a person at a point in time
 type PlayerState struct {
 	Name     string
+	Planet   int
 	Position mgl32.Vec3
+	Velocity mgl32.Vec3
 	LookDir  mgl32.Vec3
 	SendText string
+	GameMode int
+	Time     float64
+}
+
+// PlayerSummary is the low-rate update sent about players outside the view radius
+type PlayerSummary struct {
+	Name     string
+	Planet   int
+	Position mgl32.Vec3
+	Time     float64
+}
+
+// TeleportArgs are the arguments for the Teleport API call
+type TeleportArgs struct {
+	Planet   int
+	Position mgl32.Vec3
+}
+
+// WorldArgs are the arguments for the SetWorld API call
+type WorldArgs struct {
+	Name    string
+	Planets []*PlanetState
 }
@@ -0,0 +1,41 @@
+package common
+
+import "time"
+
+// Kinds of recorded events
+const (
+	RecordState = iota
+	RecordEdit
+	RecordChat
+	RecordJoin
+	RecordLeave
+)
+
+// RecordHeader starts a session recording. It is followed by a stream of
+// gob encoded RecordEvents.
+type RecordHeader struct {
+	Started time.Time
+	Worlds  []RecordedWorld
+}
+
+// RecordedWorld is a snapshot of a world's database when recording started,
+// without accounts, bans or roles
+type RecordedWorld struct {
+	Name     string
+	Database []byte
+}
+
+// RecordEvent is something that happened during a recorded session. Elapsed is
+// seconds of server time since recording started and Time is the world's
+// universe clock. Chat is recorded for all worlds, with an empty World.
+type RecordEvent struct {
+	Kind     int
+	Elapsed  float64
+	Time     float64
+	World    string
+	Name     string
+	State    PlayerState
+	Edit     RPCSetCellMaterialArgs
+	Previous int
+	Text     string
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot interpolation settings, in seconds
+const (
+	// InterpolationDelay is how far behind the newest snapshot remote players are drawn,
+	// which leaves room for late packets
+	InterpolationDelay = 0.1
+	// MaxExtrapolation is how far past the newest snapshot a remote player is predicted
+	MaxExtrapolation = 0.25
+	// offsetRelax slowly raises the clock offset estimate so it can follow rising latency
+	offsetRelax        = 0.0005
+	snapshotBufferSize = 32
+)
+
+// Now returns the current time in seconds, as used for snapshot timestamps
+func Now() float64 {
+	return float64(time.Now().UnixNano()) / float64(time.Second)
+}
+
+// SnapshotBuffer holds recent timestamped states of a remote player
+type SnapshotBuffer struct {
+	snapshots []PlayerState
+	offset    float64
+	hasOffset bool
+	mutex     *sync.Mutex
+}
+
+// NewSnapshotBuffer creates an empty snapshot buffer
+func NewSnapshotBuffer() *SnapshotBuffer {
+	b := SnapshotBuffer{}
+	b.mutex = &sync.Mutex{}
+	return &b
+}
+
+// Add stores a snapshot received at local time now
+func (b *SnapshotBuffer) Add(state PlayerState, now float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// The smallest gap between receive and send time is the best guess at the clock offset
+	offset := now - state.Time
+	if !b.hasOffset || offset < b.offset+offsetRelax {
+		b.offset = offset
+		b.hasOffset = true
+	} else {
+		b.offset += offsetRelax
+	}
+
+	// A player who switched planets starts over, since positions on different
+	// planets cannot be blended and would show up as a burst of speed
+	if n := len(b.snapshots); n > 0 && b.snapshots[n-1].Planet != state.Planet {
+		if state.Time < b.snapshots[n-1].Time {
+			return
+		}
+		b.snapshots = b.snapshots[:0]
+	}
+
+	i := sort.Search(len(b.snapshots), func(i int) bool {
+		return b.snapshots[i].Time >= state.Time
+	})
+	if i < len(b.snapshots) && b.snapshots[i].Time == state.Time {
+		return
+	}
+	b.snapshots = append(b.snapshots, PlayerState{})
+	copy(b.snapshots[i+1:], b.snapshots[i:])
+	b.snapshots[i] = state
+	if len(b.snapshots) > snapshotBufferSize {
+		b.snapshots = b.snapshots[len(b.snapshots)-snapshotBufferSize:]
+	}
+}
+
+// Sample returns the state to draw at local time now, interpolating between
+// snapshots or extrapolating a little past the newest one
+func (b *SnapshotBuffer) Sample(now float64) (PlayerState, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := len(b.snapshots)
+	if n == 0 {
+		return PlayerState{}, false
+	}
+	t := now - b.offset - InterpolationDelay
+	first := b.snapshots[0]
+	last := b.snapshots[n-1]
+	if t <= first.Time {
+		return first, true
+	}
+	if t >= last.Time {
+		ahead := t - last.Time
+		if ahead > MaxExtrapolation {
+			ahead = MaxExtrapolation
+		}
+		s := last
+		s.Position = last.Position.Add(last.Velocity.Mul(float32(ahead)))
+		return s, true
+	}
+	i := sort.Search(n, func(i int) bool {
+		return b.snapshots[i].Time > t
+	})
+	s0 := b.snapshots[i-1]
+	s1 := b.snapshots[i]
+	f := float32((t - s0.Time) / (s1.Time - s0.Time))
+	s := s1
+	if s0.Planet == s1.Planet {
+		s.Position = s0.Position.Add(s1.Position.Sub(s0.Position).Mul(f))
+		s.LookDir = s0.LookDir.Add(s1.LookDir.Sub(s0.LookDir).Mul(f)).Normalize()
+	}
+	return s, true
+}
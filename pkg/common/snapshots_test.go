@@ -0,0 +1,104 @@
+package common
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func snapshot(planet int, x, vx float32, t float64) PlayerState {
+	return PlayerState{
+		Planet:   planet,
+		Position: mgl32.Vec3{x, 0, 0},
+		Velocity: mgl32.Vec3{vx, 0, 0},
+		LookDir:  mgl32.Vec3{0, 0, 1},
+		Time:     t,
+	}
+}
+
+func TestSnapshotBufferSample(t *testing.T) {
+	tests := []struct {
+		name      string
+		snapshots []PlayerState
+		// at is the remote time sampled, before the interpolation delay is added
+		at     float64
+		planet int
+		x      float32
+	}{
+		{
+			name:      "before first",
+			snapshots: []PlayerState{snapshot(0, 1, 10, 1), snapshot(0, 2, 10, 1.1)},
+			at:        0.5,
+			planet:    0,
+			x:         1,
+		},
+		{
+			name:      "interpolate",
+			snapshots: []PlayerState{snapshot(0, 1, 10, 1), snapshot(0, 2, 10, 1.1)},
+			at:        1.05,
+			planet:    0,
+			x:         1.5,
+		},
+		{
+			name:      "interpolate out of order",
+			snapshots: []PlayerState{snapshot(0, 2, 10, 1.1), snapshot(0, 1, 10, 1), snapshot(0, 3, 10, 1.2)},
+			at:        1.15,
+			planet:    0,
+			x:         2.5,
+		},
+		{
+			name:      "extrapolate",
+			snapshots: []PlayerState{snapshot(0, 1, 10, 1), snapshot(0, 2, 10, 1.1)},
+			at:        1.2,
+			planet:    0,
+			x:         3,
+		},
+		{
+			name:      "extrapolation capped",
+			snapshots: []PlayerState{snapshot(0, 1, 10, 1), snapshot(0, 2, 10, 1.1)},
+			at:        5,
+			planet:    0,
+			x:         2 + 10*MaxExtrapolation,
+		},
+		{
+			name:      "planet switch",
+			snapshots: []PlayerState{snapshot(0, 1, 10, 1), snapshot(0, 2, 10, 1.1), snapshot(3, 50, 0, 1.2)},
+			at:        1.05,
+			planet:    3,
+			x:         50,
+		},
+		{
+			name:      "late snapshot from old planet",
+			snapshots: []PlayerState{snapshot(0, 1, 10, 1), snapshot(3, 50, 0, 1.2), snapshot(0, 2, 10, 1.1)},
+			at:        1.15,
+			planet:    3,
+			x:         50,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewSnapshotBuffer()
+			// Received with no delay, so the clock offset is zero
+			for _, s := range test.snapshots {
+				b.Add(s, s.Time)
+			}
+			s, ok := b.Sample(test.at + InterpolationDelay)
+			if !ok {
+				t.Fatal("no sample")
+			}
+			if s.Planet != test.planet {
+				t.Errorf("planet %v, want %v", s.Planet, test.planet)
+			}
+			if math.Abs(float64(s.Position[0]-test.x)) > 1e-3 {
+				t.Errorf("x %v, want %v", s.Position[0], test.x)
+			}
+		})
+	}
+}
+
+func TestSnapshotBufferEmpty(t *testing.T) {
+	if _, ok := NewSnapshotBuffer().Sample(1); ok {
+		t.Error("empty buffer gave a sample")
+	}
+}
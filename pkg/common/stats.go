@@ -0,0 +1,20 @@
+package common
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ChunkStats counts the chunk work done by planets backed by a database
+var ChunkStats struct {
+	Loaded       int64
+	Generated    int64
+	Persisted    int64
+	DBWriteNanos int64
+}
+
+// recordChunkWrite counts a chunk written to the database since start
+func recordChunkWrite(start time.Time) {
+	atomic.AddInt64(&ChunkStats.Persisted, 1)
+	atomic.AddInt64(&ChunkStats.DBWriteNanos, int64(time.Since(start)))
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+)
+
+// ChunkData holds a compressed chunk streamed from the server
+type ChunkData struct {
+	Planet int
+	ChunkIndex
+	Data []byte
+}
+
+// EncodeChunk serializes and compresses a chunk
+func EncodeChunk(chunk *Chunk) ([]byte, error) {
+	var buf bytes.Buffer
+	w, e := flate.NewWriter(&buf, flate.BestSpeed)
+	if e != nil {
+		return nil, e
+	}
+	e = gob.NewEncoder(w).Encode(chunk)
+	if e != nil {
+		return nil, e
+	}
+	e = w.Close()
+	if e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeChunk decompresses and deserializes a chunk
+func DecodeChunk(data []byte) (*Chunk, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	var chunk Chunk
+	e := gob.NewDecoder(r).Decode(&chunk)
+	if e != nil {
+		return nil, e
+	}
+	return &chunk, nil
+}
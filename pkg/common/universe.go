@@ -4,15 +4,21 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/gob"
+	"sync"
+	"time"
 
 	opensimplex "github.com/ojrac/opensimplex-go"
 )
 
 // Universe stores the set of planets in a universe
 type Universe struct {
-	seed      int
-	noise     *opensimplex.Noise
-	PlanetMap map[int]*Planet
+	seed       int
+	noise      *opensimplex.Noise
+	db         *sql.DB
+	clockStart time.Time
+	clockBase  float64
+	clockMutex *sync.Mutex
+	PlanetMap  map[int]*Planet
 }
 
 // NewUniverse creates a universe with a given seed
@@ -20,6 +26,10 @@ func NewUniverse(db *sql.DB, systemType string) *Universe {
 	u := Universe{}
 	u.noise = opensimplex.NewWithSeed(0)
 	u.PlanetMap = make(map[int]*Planet)
+	u.db = db
+	u.clockMutex = &sync.Mutex{}
+	u.clockStart = time.Now()
+	u.clockBase = queryClock(db)
 	planetStates := queryPlanetStates(db)
 
 	// If no planets in the database, generate a planetary system
@@ -43,6 +53,40 @@ func NewUniverse(db *sql.DB, systemType string) *Universe {
 	return &u
 }
 
+// Time returns the universe clock in seconds, which drives planet orbits and rotation
+func (u *Universe) Time() float64 {
+	u.clockMutex.Lock()
+	defer u.clockMutex.Unlock()
+	return u.clockBase + time.Since(u.clockStart).Seconds()
+}
+
+// SetTime sets the universe clock and saves it
+func (u *Universe) SetTime(seconds float64) error {
+	u.clockMutex.Lock()
+	u.clockBase = seconds
+	u.clockStart = time.Now()
+	u.clockMutex.Unlock()
+	return u.SaveTime()
+}
+
+// SaveTime stores the universe clock so it continues from there after a restart
+func (u *Universe) SaveTime() error {
+	_, err := u.db.Exec("INSERT OR REPLACE INTO clock VALUES (0, ?)", u.Time())
+	return err
+}
+
+func queryClock(db *sql.DB) float64 {
+	var seconds float64
+	err := db.QueryRow("SELECT seconds FROM clock WHERE id = 0").Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0
+	}
+	if err != nil {
+		panic(err)
+	}
+	return seconds
+}
+
 func queryPlanetStates(db *sql.DB) []*PlanetState {
 	states := []*PlanetState{}
 	rows, err := db.Query("SELECT data FROM planet")
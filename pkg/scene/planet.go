@@ -175,6 +175,19 @@ func NewPlanet(planet *common.Planet) *Planet {
 // SetCellMaterial sets the material at a particular cell and marks its chunk for redraw
 func (planetRen *Planet) SetCellMaterial(ind common.CellIndex, material int, updateServer bool) {
 	planetRen.Planet.SetCellMaterial(ind, material, updateServer)
+	planetRen.cellChanged(ind)
+}
+
+// SetCellMaterials sets the materials of many cells, then marks each changed
+// chunk for redraw so it is rebuilt once rather than once per cell
+func (planetRen *Planet) SetCellMaterials(edits []common.RPCSetCellMaterialArgs, updateServer bool) {
+	for _, edit := range planetRen.Planet.SetCellMaterials(edits, updateServer) {
+		planetRen.cellChanged(edit.Index)
+	}
+}
+
+// cellChanged marks the chunk holding a cell, and any neighbor sharing its face, for redraw
+func (planetRen *Planet) cellChanged(ind common.CellIndex) {
 	chunkInd := planetRen.Planet.CellIndexToChunkIndex(ind)
 	chunkRen := planetRen.chunkRenderers[chunkInd]
 	if chunkRen == nil {
@@ -235,6 +248,16 @@ func (planetRen *Planet) SetCellMaterial(ind common.CellIndex, material int, upd
 	}
 }
 
+// ReceiveChunk stores a streamed chunk and rebuilds its geometry if it was already drawn
+func (planetRen *Planet) ReceiveChunk(ind common.ChunkIndex, chunk *common.Chunk) {
+	planetRen.Planet.ReceiveChunk(ind, chunk)
+	cr := planetRen.chunkRenderers[ind]
+	if cr != nil {
+		cr.chunk = chunk
+		cr.geometryUpdated = false
+	}
+}
+
 func (planetRen *Planet) location(time float64, planetMap map[int]*Planet) mgl32.Vec3 {
 	planet := planetRen.Planet
 	if planet.ID == planet.OrbitPlanet {
@@ -256,7 +279,7 @@ func (planetRen *Planet) location(time float64, planetMap map[int]*Planet) mgl32
 // Draw draws the planet's visible chunks
 func (planetRen *Planet) Draw(player *common.Player, planetMap map[int]*Planet, w *glfw.Window, time float64) {
 	loc := player.Location()
-	planetRotation := time / planetRen.Planet.RotationSeconds
+	_, planetRotation := math.Modf(time / planetRen.Planet.RotationSeconds)
 	planetRotation *= 2 * math.Pi
 	orbitPosition := time / planetRen.Planet.OrbitSeconds
 	orbitPosition *= 2 * math.Pi
@@ -7,9 +7,9 @@ import (
 	"github.com/jeffbaumes/buildorb/pkg/common"
 )
 
-// Players draws the other players in the game
+// Players draws the other players in the game at their interpolated positions
 type Players struct {
-	connectedPlayers  *[]*common.PlayerState
+	universe          *Universe
 	program           uint32
 	drawableVAO       uint32
 	pointsVBO         uint32
@@ -18,7 +18,7 @@ type Players struct {
 }
 
 // NewPlayers creates a new Players object
-func NewPlayers(cp *[]*common.PlayerState) *Players {
+func NewPlayers(u *Universe) *Players {
 	const vertexShader = `
 		#version 410
 		in vec3 vp;
@@ -53,7 +53,7 @@ func NewPlayers(cp *[]*common.PlayerState) *Players {
 	`
 
 	peopleRen := Players{}
-	peopleRen.connectedPlayers = cp
+	peopleRen.universe = u
 	peopleRen.program = createProgram(vertexShader, fragmentShader)
 	bindAttribute(peopleRen.program, 0, "vp")
 	bindAttribute(peopleRen.program, 1, "n")
@@ -68,7 +68,12 @@ func NewPlayers(cp *[]*common.PlayerState) *Players {
 // Draw draws the other players
 func (peopleRen *Players) Draw(player *common.Player, w *glfw.Window) {
 	gl.UseProgram(peopleRen.program)
-	for _, p := range *peopleRen.connectedPlayers {
+	now := peopleRen.universe.Time()
+	for _, latest := range peopleRen.universe.ConnectedPeople {
+		p, ok := peopleRen.universe.PlayerSnapshot(latest.Name, now)
+		if !ok || p.Planet != player.Planet.ID || p.Name == peopleRen.universe.Following {
+			continue
+		}
 		pts := make([]float32, len(cube))
 		for i := 0; i < len(cube); i += 3 {
 			pts[i] = p.Position[0] + cube[i]
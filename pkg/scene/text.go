@@ -15,6 +15,7 @@ type Text struct {
 
 var o int
 var tex1 *gui.Label
+var status *gui.Label
 var texte *gui.Entry
 var textl [5]*gui.Label
 var oldtext [4]string
@@ -25,6 +26,7 @@ func (text *Text) Draw(player *common.Player, screen *gui.Screen, u *Universe) {
 	r, theta, phi := mgl32.CartesianToSpherical(player.Location())
 	if o == 0 {
 		tex1 = gui.NewLabel(screen, "", -0.95, -0.95, 0.05)
+		status = gui.NewLabel(screen, "", -0.3, 0.2, 0.1)
 		for x := range textl {
 			textl[x] = gui.NewLabel(screen, "", -0.95, float64(0.85-float64(x)*0.10), 0.08-float64(x)*0.008)
 		}
@@ -32,12 +34,13 @@ func (text *Text) Draw(player *common.Player, screen *gui.Screen, u *Universe) {
 		texte = gui.NewEntry(screen, "", -0.75, -0.85, 1.5, 0.2, 0.04, func() {
 			player.Mode = "Play"
 			var ret bool
-			u.RPC.Go("API.SendText", fmt.Sprintf("%v: %v", player.Name, texte.Text), &ret, nil)
+			u.RPC().Go("API.SendText", texte.Text, &ret, nil)
 			texte.Text = ""
 		})
 		o = 1
 	}
 	tex1.Text = fmt.Sprintf("LAT %v, LON %v, ALT %v", int(theta/math.Pi*180-90+0.5), int(phi/math.Pi*180+0.5), int(r+0.5))
+	status.Text = u.Status()
 	if player.Mode == "Text" {
 		texte.Y = -0.85
 		texte.Focus = true
@@ -3,6 +3,7 @@ package scene
 import (
 	"math"
 	"net/rpc"
+	"sync"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
@@ -15,7 +16,14 @@ type Universe struct {
 	Player          *common.Player
 	PlanetMap       map[int]*Planet
 	ConnectedPeople []*common.PlayerState
-	RPC             *rpc.Client
+	Following       string // the player whose view a spectator is attached to
+	rpc             *rpc.Client
+	status          string
+	connMutex       *sync.Mutex
+	snapshots       map[string]*common.SnapshotBuffer
+	snapshotsMutex  *sync.Mutex
+	timeOffset      float64
+	timeMutex       *sync.Mutex
 }
 
 // NewUniverse creates a new universe
@@ -23,10 +31,90 @@ func NewUniverse(player *common.Player, rpc *rpc.Client) *Universe {
 	u := Universe{}
 	u.Player = player
 	u.PlanetMap = make(map[int]*Planet)
-	u.RPC = rpc
+	u.rpc = rpc
+	u.connMutex = &sync.Mutex{}
+	u.snapshots = make(map[string]*common.SnapshotBuffer)
+	u.snapshotsMutex = &sync.Mutex{}
+	u.timeMutex = &sync.Mutex{}
 	return &u
 }
 
+// SetRPC switches the universe to a new server connection
+func (u *Universe) SetRPC(rpc *rpc.Client) {
+	u.connMutex.Lock()
+	u.rpc = rpc
+	u.connMutex.Unlock()
+}
+
+// RPC returns the current server connection
+func (u *Universe) RPC() *rpc.Client {
+	u.connMutex.Lock()
+	defer u.connMutex.Unlock()
+	return u.rpc
+}
+
+// SetStatus sets the connection status shown to the player, empty when connected
+func (u *Universe) SetStatus(status string) {
+	u.connMutex.Lock()
+	u.status = status
+	u.connMutex.Unlock()
+}
+
+// Status returns the connection status shown to the player
+func (u *Universe) Status() string {
+	u.connMutex.Lock()
+	defer u.connMutex.Unlock()
+	return u.status
+}
+
+// SetTimeOffset sets the difference between the server's universe clock and local time
+func (u *Universe) SetTimeOffset(offset float64) {
+	u.timeMutex.Lock()
+	u.timeOffset = offset
+	u.timeMutex.Unlock()
+}
+
+// Time returns the universe clock in seconds as estimated from the server
+func (u *Universe) Time() float64 {
+	u.timeMutex.Lock()
+	defer u.timeMutex.Unlock()
+	return common.Now() + u.timeOffset
+}
+
+// AddSnapshot records a state update for another player received at local time now
+func (u *Universe) AddSnapshot(state *common.PlayerState, now float64) {
+	u.snapshotsMutex.Lock()
+	b := u.snapshots[state.Name]
+	if b == nil {
+		b = common.NewSnapshotBuffer()
+		u.snapshots[state.Name] = b
+	}
+	u.snapshotsMutex.Unlock()
+	b.Add(*state, now)
+}
+
+// RemoveSnapshots forgets the snapshots of a player, or of everyone if name is empty
+func (u *Universe) RemoveSnapshots(name string) {
+	u.snapshotsMutex.Lock()
+	if name == "" {
+		u.snapshots = make(map[string]*common.SnapshotBuffer)
+	} else {
+		delete(u.snapshots, name)
+	}
+	u.snapshotsMutex.Unlock()
+}
+
+// PlayerSnapshot returns the smoothed state of another player at local time now
+func (u *Universe) PlayerSnapshot(name string, now float64) (common.PlayerState, bool) {
+	u.snapshotsMutex.Lock()
+	b := u.snapshots[name]
+	u.snapshotsMutex.Unlock()
+	if b == nil {
+		return common.PlayerState{}, false
+	}
+	return b.Sample(now)
+}
+
 // AddPlanet adds a planet to the planet map
 func (u *Universe) AddPlanet(planet *Planet) {
 	u.PlanetMap[planet.Planet.ID] = planet
@@ -37,7 +125,7 @@ func (u *Universe) Draw(w *glfw.Window, time float64) {
 	player := u.Player
 	loc := player.Location()
 	planetRen := u.PlanetMap[player.Planet.ID]
-	planetRotation := time / planetRen.Planet.RotationSeconds
+	_, planetRotation := math.Modf(time / planetRen.Planet.RotationSeconds)
 	planetRotation *= 2 * math.Pi
 	orbitPosition := time / planetRen.Planet.OrbitSeconds
 	orbitPosition *= 2 * math.Pi
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed login limits per name, so passwords cannot be guessed quickly
+const (
+	loginFailureRate  = 0.2
+	loginFailureBurst = 5
+	// maxLoginFailureNames is how many names are tracked before names that
+	// have waited out their failures are forgotten
+	maxLoginFailureNames = 1024
+)
+
+var (
+	validName    = regexp.MustCompile(`^[A-Za-z0-9_]{1,16}$`)
+	accountMutex = &sync.Mutex{}
+
+	errTooManyFailures = errors.New("Too many failed logins, try again later")
+
+	loginFailures      = map[string]*tokenBucket{}
+	loginFailuresMutex = &sync.Mutex{}
+)
+
+// loginFailureLimit returns the bucket that failed logins for a name are taken from
+func loginFailureLimit(name string) *tokenBucket {
+	loginFailuresMutex.Lock()
+	defer loginFailuresMutex.Unlock()
+	b := loginFailures[name]
+	if b == nil {
+		if len(loginFailures) >= maxLoginFailureNames {
+			for n, old := range loginFailures {
+				if old.available(loginFailureBurst) {
+					delete(loginFailures, n)
+				}
+			}
+		}
+		b = newTokenBucket(loginFailureRate, loginFailureBurst)
+		loginFailures[name] = b
+	}
+	return b
+}
+
+func createAccountTable(db *sql.DB) {
+	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS account (name TEXT PRIMARY KEY, hash BLOB, token BLOB)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+}
+
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+func newToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	checkErr(err)
+	return hex.EncodeToString(b)
+}
+
+// authenticate checks a name and password or token, creating the account on first
+// login unless the name is reserved. It returns a fresh token that can be used for later logins.
+func authenticate(db *sql.DB, name, password, token string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", errors.New("Names must be 1 to 16 letters, digits or underscores")
+	}
+	var hash, tokenHash []byte
+	err := db.QueryRow("SELECT hash, token FROM account WHERE name = ?", name).Scan(&hash, &tokenHash)
+	if err == sql.ErrNoRows {
+		if password == "" {
+			return "", errors.New("A password is needed to create an account")
+		}
+		if isReservedName(db, name) {
+			return "", errors.New("This name must be registered by an operator first")
+		}
+		if err := createAccount(db, name, password); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	} else if password != "" {
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+			return "", errors.New("Wrong password")
+		}
+	} else if token == "" || tokenHash == nil || subtle.ConstantTimeCompare(hashToken(token), tokenHash) != 1 {
+		return "", errors.New("Invalid login token")
+	}
+	newTok := newToken()
+	_, err = db.Exec("UPDATE account SET token = ? WHERE name = ?", hashToken(newTok), name)
+	if err != nil {
+		return "", err
+	}
+	return newTok, nil
+}
+
+// isReservedName returns whether a name is listed in ops or has a role, so its
+// account can only be made with the register command
+func isReservedName(db *sql.DB, name string) bool {
+	if isOp(name) {
+		return true
+	}
+	var role string
+	err := db.QueryRow("SELECT role FROM role WHERE name = ?", name).Scan(&role)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	checkErr(err)
+	return true
+}
+
+// accountExists returns whether a name has an account
+func accountExists(db *sql.DB, name string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM account WHERE name = ?", name).Scan(&n)
+	return n > 0, err
+}
+
+// createAccount makes a new account, failing if the name was taken meanwhile
+func createAccount(db *sql.DB, name, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec("INSERT OR IGNORE INTO account VALUES (?, ?, NULL)", name, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("This name was just taken")
+	}
+	return nil
+}
+
+// setPassword creates an account or replaces its password, ending its token logins
+func setPassword(db *sql.DB, name, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO account VALUES (?, ?, NULL)", name, hash)
+	return err
+}
+
+func registerCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 2, commands["register"].usage); e != nil {
+		return e
+	}
+	if !validName.MatchString(args[0]) {
+		return errors.New("Names must be 1 to 16 letters, digits or underscores")
+	}
+	accountMutex.Lock()
+	e := setPassword(db, args[0], args[1])
+	accountMutex.Unlock()
+	if e != nil {
+		return e
+	}
+	ctx.replyf("Registered %v", args[0])
+	return nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var errBanned = errors.New("Banned from this server")
+
+func createBanTable(db *sql.DB) {
+	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS ban (name TEXT PRIMARY KEY, reason TEXT)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+}
+
+// isBanned returns whether a name may not log in
+func isBanned(db *sql.DB, name string) bool {
+	var reason string
+	err := db.QueryRow("SELECT reason FROM ban WHERE name = ?", name).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	checkErr(err)
+	return true
+}
+
+// ban stops a name from logging in
+func ban(db *sql.DB, name, reason string) {
+	_, err := db.Exec("INSERT OR REPLACE INTO ban VALUES (?, ?)", name, reason)
+	checkErr(err)
+}
+
+// unban lets a name log in again, returning false if it was not banned
+func unban(db *sql.DB, name string) bool {
+	res, err := db.Exec("DELETE FROM ban WHERE name = ?", name)
+	checkErr(err)
+	n, err := res.RowsAffected()
+	checkErr(err)
+	return n > 0
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/jeffbaumes/buildorb/pkg/bot"
+)
+
+func TestBotOverPipe(t *testing.T) {
+	dir := t.TempDir()
+	wd, e := os.Getwd()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Chdir(dir); e != nil {
+		t.Fatal(e)
+	}
+	defer os.Chdir(wd)
+	if e := os.Mkdir("worlds", 0755); e != nil {
+		t.Fatal(e)
+	}
+	openWorlds("test")
+	defer mainWorld.db.Close()
+
+	serverConn, clientConn := net.Pipe()
+	go serveConn(serverConn)
+	b, e := bot.Connect(clientConn, "tester", "secret")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer b.Close()
+
+	home := mainWorld.planet(0)
+	if b.Planet(0) == nil {
+		t.Fatal("bot has no home planet")
+	}
+	if r := b.Position().Len(); r < float32(home.Radius)/2 || r > float32(home.Radius)+10 {
+		t.Errorf("bot spawned %v from the planet center, radius is %v", r, home.Radius)
+	}
+	if findPerson("tester") == nil {
+		t.Error("bot did not join the game")
+	}
+	if e := b.Chat("hello"); e != nil {
+		t.Fatal(e)
+	}
+	history := chatHistory()
+	if len(history) == 0 || history[len(history)-1].line() != "tester: hello" {
+		t.Errorf("chat was not logged: %v", history)
+	}
+}
+
+func TestBotConnectClosed(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	serverConn.Close()
+	if _, e := bot.Connect(clientConn, "tester", "secret"); e == nil {
+		t.Error("connected over a closed pipe")
+	}
+}
@@ -0,0 +1,504 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// chatHistorySize is how many recent chat messages the admin API returns
+const chatHistorySize = 100
+
+// defaultJoinHistory is how many chat messages players are sent when they join,
+// overridable with the chat_history key in server.buildorb
+const defaultJoinHistory = 20
+
+// Chat channels. Plain chat lines go to the channel the person picked with the channel command.
+const (
+	chatGlobal  = "global"
+	chatPlanet  = "planet"
+	chatTeam    = "team"
+	chatWhisper = "whisper"
+)
+
+var chatChannels = []string{chatGlobal, chatPlanet, chatTeam}
+
+// teamMutex is held while checking and changing team membership, so two
+// players cannot both found the same team
+var teamMutex = &sync.Mutex{}
+
+// chatMessage is a line of chat. Sender is empty for server messages.
+type chatMessage struct {
+	Time      time.Time
+	Channel   string
+	World     string
+	Planet    int
+	Team      string
+	Sender    string
+	Recipient string
+	Text      string
+}
+
+func createChatTables(db *sql.DB) {
+	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS chat (id INTEGER PRIMARY KEY, time INT, channel TEXT, world TEXT, planet INT, team TEXT, sender TEXT, recipient TEXT, text TEXT)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS team (name TEXT PRIMARY KEY, team TEXT)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS team_invite (name TEXT, team TEXT, PRIMARY KEY (name, team))")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS mute (name TEXT, muted TEXT, PRIMARY KEY (name, muted))")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+}
+
+// line formats the message for display
+func (m *chatMessage) line() string {
+	switch {
+	case m.Sender == "":
+		return m.Text
+	case m.Channel == chatWhisper:
+		return fmt.Sprintf("[%v -> %v] %v", m.Sender, m.Recipient, m.Text)
+	case m.Channel == chatGlobal:
+		return m.Sender + ": " + m.Text
+	}
+	return fmt.Sprintf("[%v] %v: %v", m.Channel, m.Sender, m.Text)
+}
+
+// visibleTo returns whether a person should see the message
+func (m *chatMessage) visibleTo(c *connectedPerson) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	name := c.state.Name
+	if name != m.Sender && c.muted[m.Sender] {
+		return false
+	}
+	switch m.Channel {
+	case chatPlanet:
+		return c.world != nil && c.world.name == m.World && c.state.Planet == m.Planet
+	case chatTeam:
+		return c.team != "" && c.team == m.Team
+	case chatWhisper:
+		return name == m.Sender || name == m.Recipient
+	}
+	return true
+}
+
+// addChat stores a chat message in the chat log
+func addChat(m chatMessage) {
+	if m.Channel == chatGlobal {
+		record(nil, common.RecordEvent{Kind: common.RecordChat, Text: m.line()})
+	}
+	_, err := db.Exec("INSERT INTO chat (time, channel, world, planet, team, sender, recipient, text) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		m.Time.Unix(), m.Channel, m.World, m.Planet, m.Team, m.Sender, m.Recipient, m.Text)
+	if err != nil {
+		log.Println("Chat log error:", err)
+	}
+}
+
+// sendChat logs a chat message and sends it to everyone who can see it
+func sendChat(m chatMessage) {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	addChat(m)
+	text := m.line()
+	broadcast("API.SendText", &text, m.visibleTo)
+}
+
+// personChat sends a chat line from a person to a channel
+func personChat(c *connectedPerson, channel, text string) error {
+	c.mutex.Lock()
+	m := chatMessage{Channel: channel, Sender: c.state.Name, Text: text}
+	if c.world != nil {
+		m.World = c.world.name
+	}
+	m.Planet = c.state.Planet
+	m.Team = c.team
+	c.mutex.Unlock()
+	if channel == chatTeam && m.Team == "" {
+		return errors.New("you are not on a team, use team <name> to join one")
+	}
+	sendChat(m)
+	return nil
+}
+
+// whisper sends a private message to a connected player
+func whisper(from *connectedPerson, to *connectedPerson, text string) {
+	sender := "server"
+	if from != nil {
+		sender = from.getState().Name
+	}
+	to.mutex.Lock()
+	recipient := to.state.Name
+	to.lastWhisper = sender
+	to.mutex.Unlock()
+	sendChat(chatMessage{Channel: chatWhisper, Sender: sender, Recipient: recipient, Text: text})
+}
+
+// chatLog returns up to n of the latest logged messages matching an SQL condition, oldest first
+func chatLog(n int, where string, args ...interface{}) []chatMessage {
+	rows, err := db.Query("SELECT time, channel, world, planet, team, sender, recipient, text FROM chat WHERE "+where+" ORDER BY id DESC LIMIT ?", append(args, n)...)
+	if err != nil {
+		log.Println("Chat log error:", err)
+		return nil
+	}
+	defer rows.Close()
+	list := []chatMessage{}
+	for rows.Next() {
+		var m chatMessage
+		var seconds int64
+		if err := rows.Scan(&seconds, &m.Channel, &m.World, &m.Planet, &m.Team, &m.Sender, &m.Recipient, &m.Text); err != nil {
+			log.Println("Chat log error:", err)
+			return nil
+		}
+		m.Time = time.Unix(seconds, 0)
+		list = append(list, m)
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// chatHistory returns the recent global chat messages, oldest first
+func chatHistory() []chatMessage {
+	return chatLog(chatHistorySize, "channel = ?", chatGlobal)
+}
+
+// sendChatHistory sends a person who just joined the recent messages they can see
+func (c *connectedPerson) sendChatHistory() {
+	for _, m := range c.chatHistory(getconfigint("chat_history", defaultJoinHistory)) {
+		c.tell(m.line())
+	}
+}
+
+// chatHistory returns up to n of the latest messages the person can see, checked
+// the same way as visibleTo, oldest first
+func (c *connectedPerson) chatHistory(n int) []chatMessage {
+	c.mutex.Lock()
+	name := c.state.Name
+	planet := c.state.Planet
+	team := c.team
+	world := ""
+	if c.world != nil {
+		world = c.world.name
+	}
+	c.mutex.Unlock()
+	where := "(channel = ? OR (channel = ? AND world = ? AND planet = ?) OR (channel = ? AND (sender = ? OR recipient = ?))"
+	args := []interface{}{chatGlobal, chatPlanet, world, planet, chatWhisper, name, name}
+	if team != "" {
+		where += " OR (channel = ? AND team = ?)"
+		args = append(args, chatTeam, team)
+	}
+	where += ") AND (sender = ? OR sender NOT IN (SELECT muted FROM mute WHERE name = ?))"
+	args = append(args, name, name)
+	return chatLog(n, where, args...)
+}
+
+// loadChatSettings reads the person's team and muted players
+func (c *connectedPerson) loadChatSettings(db *sql.DB, name string) error {
+	var team string
+	err := db.QueryRow("SELECT team FROM team WHERE name = ?", name).Scan(&team)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	muted := make(map[string]bool)
+	rows, err := db.Query("SELECT muted FROM mute WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return err
+		}
+		muted[m] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.team = team
+	c.muted = muted
+	c.mutex.Unlock()
+	return nil
+}
+
+func msgCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 2, commands["msg"].usage); e != nil {
+		return e
+	}
+	to, e := needPerson(args[0])
+	if e != nil {
+		return e
+	}
+	if to == ctx.person {
+		return errors.New("cannot message yourself")
+	}
+	whisper(ctx.person, to, strings.Join(args[1:], " "))
+	if ctx.person == nil {
+		ctx.replyf("To %v: %v", args[0], strings.Join(args[1:], " "))
+	}
+	return nil
+}
+
+func replyCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	if e := needArgs(args, 1, commands["reply"].usage); e != nil {
+		return e
+	}
+	ctx.person.mutex.Lock()
+	name := ctx.person.lastWhisper
+	ctx.person.mutex.Unlock()
+	if name == "" {
+		return errors.New("nobody has messaged you")
+	}
+	if name == "server" {
+		return errors.New("cannot reply to the server")
+	}
+	to, e := needPerson(name)
+	if e != nil {
+		return e
+	}
+	whisper(ctx.person, to, strings.Join(args, " "))
+	return nil
+}
+
+func channelCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	if len(args) == 0 {
+		ctx.person.mutex.Lock()
+		channel := ctx.person.chatChannel
+		ctx.person.mutex.Unlock()
+		ctx.replyf("Chatting in %v", channel)
+		return nil
+	}
+	channel := args[0]
+	valid := false
+	for _, ch := range chatChannels {
+		valid = valid || ch == channel
+	}
+	if !valid {
+		return errors.New("usage: " + commands["channel"].usage)
+	}
+	if len(args) > 1 {
+		return personChat(ctx.person, channel, strings.Join(args[1:], " "))
+	}
+	ctx.person.mutex.Lock()
+	ctx.person.chatChannel = channel
+	ctx.person.mutex.Unlock()
+	ctx.replyf("Chatting in %v", channel)
+	return nil
+}
+
+func teamCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	c := ctx.person
+	name := c.getState().Name
+	c.mutex.Lock()
+	current := c.team
+	c.mutex.Unlock()
+	if len(args) == 0 {
+		if current == "" {
+			ctx.reply("You are not on a team")
+			return nil
+		}
+		members := []string{}
+		for _, o := range people() {
+			o.mutex.Lock()
+			if o.team == current {
+				members = append(members, o.state.Name)
+			}
+			o.mutex.Unlock()
+		}
+		sort.Strings(members)
+		ctx.replyf("Team %v, online: %v", current, strings.Join(members, ", "))
+		return nil
+	}
+	if args[0] == "invite" {
+		return teamInvite(ctx, current, args[1:])
+	}
+	team := args[0]
+	if team == "leave" {
+		team = ""
+	}
+	if team == current {
+		return nil
+	}
+	teamMutex.Lock()
+	defer teamMutex.Unlock()
+	if team == "" {
+		if _, err := db.Exec("DELETE FROM team WHERE name = ?", name); err != nil {
+			return err
+		}
+	} else {
+		// Anyone may found a team nobody is on, but joining one with members takes an invite
+		members, err := teamSize(team)
+		if err != nil {
+			return err
+		}
+		if members > 0 {
+			res, err := db.Exec("DELETE FROM team_invite WHERE name = ? AND team = ?", name, team)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				return fmt.Errorf("team %v is invite only, ask a member to run team invite %v", team, name)
+			}
+		}
+		if _, err := db.Exec("INSERT OR REPLACE INTO team VALUES (?, ?)", name, team); err != nil {
+			return err
+		}
+	}
+	c.mutex.Lock()
+	c.team = team
+	c.mutex.Unlock()
+	if current != "" {
+		if err := disbandIfEmpty(current); err != nil {
+			log.Println("Team error:", err)
+		}
+	}
+	if team == "" {
+		ctx.reply("Left your team")
+	} else {
+		ctx.replyf("Joined team %v", team)
+	}
+	return nil
+}
+
+// teamInvite lets a player join the team of the person running the command
+func teamInvite(ctx *commandContext, team string, args []string) error {
+	if e := needArgs(args, 1, "team invite <player>"); e != nil {
+		return e
+	}
+	if team == "" {
+		return errors.New("you are not on a team")
+	}
+	exists, err := accountExists(db, args[0])
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("there is no player named %v", args[0])
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO team_invite VALUES (?, ?)", args[0], team); err != nil {
+		return err
+	}
+	if to := findPerson(args[0]); to != nil {
+		to.tell(fmt.Sprintf("%v invited you to team %v, run team %v to join", ctx.person.getState().Name, team, team))
+	}
+	ctx.replyf("Invited %v to team %v", args[0], team)
+	return nil
+}
+
+// teamSize returns how many players are on a team, online or not
+func teamSize(team string) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM team WHERE team = ?", team).Scan(&n)
+	return n, err
+}
+
+// disbandIfEmpty forgets a team everyone has left, with its invites and
+// messages, so whoever founds a team with the name next cannot read them.
+// The caller holds teamMutex.
+func disbandIfEmpty(team string) error {
+	members, err := teamSize(team)
+	if err != nil || members > 0 {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM team_invite WHERE team = ?", team); err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM chat WHERE channel = ? AND team = ?", chatTeam, team)
+	return err
+}
+
+func muteCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	c := ctx.person
+	name := c.getState().Name
+	if len(args) == 0 {
+		c.mutex.Lock()
+		list := []string{}
+		for m := range c.muted {
+			list = append(list, m)
+		}
+		c.mutex.Unlock()
+		if len(list) == 0 {
+			ctx.reply("Nobody is muted")
+			return nil
+		}
+		sort.Strings(list)
+		ctx.replyf("Muted: %v", strings.Join(list, ", "))
+		return nil
+	}
+	if args[0] == name {
+		return errors.New("cannot mute yourself")
+	}
+	exists, err := accountExists(db, args[0])
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("there is no player named %v", args[0])
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO mute VALUES (?, ?)", name, args[0]); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.muted[args[0]] = true
+	c.mutex.Unlock()
+	ctx.replyf("Muted %v", args[0])
+	return nil
+}
+
+func unmuteCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	if e := needArgs(args, 1, commands["unmute"].usage); e != nil {
+		return e
+	}
+	c := ctx.person
+	res, err := db.Exec("DELETE FROM mute WHERE name = ? AND muted = ?", c.getState().Name, args[0])
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%v is not muted", args[0])
+	}
+	c.mutex.Lock()
+	delete(c.muted, args[0])
+	c.mutex.Unlock()
+	ctx.replyf("Unmuted %v", args[0])
+	return nil
+}
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Claim limits for players who are not admins
+const (
+	defaultSpawnProtection = 8
+	defaultMaxClaimRadius  = 32
+	defaultMaxClaims       = 3
+)
+
+// claim protects a region of a planet. Members may always build and break
+// there; the flags say what everyone else may do. A lon range with LonMin
+// greater than LonMax wraps around the planet. Spawn claims have no owner or
+// members, since any name could be registered as an account.
+type claim struct {
+	ID      int
+	Planet  int
+	LonMin  int
+	LonMax  int
+	LatMin  int
+	LatMax  int
+	AltMin  int
+	AltMax  int
+	Owner   string
+	Members []string
+	Build   bool
+	Break   bool
+	PvP     bool
+	Spawn   bool
+}
+
+// spawnProtection is the radius in cells of the claim around each planet's spawn point
+var spawnProtection = defaultSpawnProtection
+
+// claim actions
+const (
+	actionBuild = "build"
+	actionBreak = "break"
+	actionPvP   = "pvp"
+)
+
+var errClaimed = errors.New("Protected by a claim")
+
+func createClaimTable(db *sql.DB) {
+	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS claim (id INTEGER PRIMARY KEY, data BLOB)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+}
+
+// loadClaims reads all of a world's claims from its database
+func (w *world) loadClaims() {
+	rows, err := w.db.Query("SELECT data FROM claim")
+	checkErr(err)
+	defer rows.Close()
+	w.claimsMutex.Lock()
+	defer w.claimsMutex.Unlock()
+	w.claims = nil
+	for rows.Next() {
+		var data []byte
+		checkErr(rows.Scan(&data))
+		var c claim
+		checkErr(gob.NewDecoder(bytes.NewReader(data)).Decode(&c))
+		w.claims = append(w.claims, &c)
+	}
+}
+
+// saveClaim stores a claim, giving it an ID if it is new
+func saveClaim(db *sql.DB, c *claim) {
+	if c.ID == 0 {
+		res, err := db.Exec("INSERT INTO claim (data) VALUES (NULL)")
+		checkErr(err)
+		id, err := res.LastInsertId()
+		checkErr(err)
+		c.ID = int(id)
+	}
+	var buf bytes.Buffer
+	checkErr(gob.NewEncoder(&buf).Encode(c))
+	_, err := db.Exec("UPDATE claim SET data = ? WHERE id = ?", buf.Bytes(), c.ID)
+	checkErr(err)
+}
+
+func deleteClaim(db *sql.DB, id int) {
+	_, err := db.Exec("DELETE FROM claim WHERE id = ?", id)
+	checkErr(err)
+}
+
+// lonRanges splits a possibly wrapping lon range into plain ranges
+func (c *claim) lonRanges() [][2]int {
+	if c.LonMin <= c.LonMax {
+		return [][2]int{{c.LonMin, c.LonMax}}
+	}
+	return [][2]int{{c.LonMin, 1 << 30}, {-1 << 30, c.LonMax}}
+}
+
+func (c *claim) contains(planet int, ind common.CellIndex) bool {
+	if planet != c.Planet || ind.Lat < c.LatMin || ind.Lat > c.LatMax || ind.Alt < c.AltMin || ind.Alt > c.AltMax {
+		return false
+	}
+	for _, r := range c.lonRanges() {
+		if ind.Lon >= r[0] && ind.Lon <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *claim) overlaps(o *claim) bool {
+	if c.Planet != o.Planet || c.LatMax < o.LatMin || o.LatMax < c.LatMin || c.AltMax < o.AltMin || o.AltMax < c.AltMin {
+		return false
+	}
+	for _, a := range c.lonRanges() {
+		for _, b := range o.lonRanges() {
+			if a[0] <= b[1] && b[0] <= a[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *claim) isMember(name string) bool {
+	if c.Spawn {
+		return false
+	}
+	if name == c.Owner {
+		return true
+	}
+	for _, m := range c.Members {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *claim) allows(action string) bool {
+	switch action {
+	case actionBuild:
+		return c.Build
+	case actionBreak:
+		return c.Break
+	case actionPvP:
+		return c.PvP
+	}
+	return false
+}
+
+func (c *claim) String() string {
+	if c.Spawn {
+		return fmt.Sprintf("spawn protection on planet %v lon %v-%v lat %v-%v build=%v break=%v pvp=%v",
+			c.Planet, c.LonMin, c.LonMax, c.LatMin, c.LatMax, c.Build, c.Break, c.PvP)
+	}
+	members := "none"
+	if len(c.Members) > 0 {
+		members = strings.Join(c.Members, ", ")
+	}
+	return fmt.Sprintf("#%v on planet %v lon %v-%v lat %v-%v alt %v-%v owner %v members %v build=%v break=%v pvp=%v",
+		c.ID, c.Planet, c.LonMin, c.LonMax, c.LatMin, c.LatMax, c.AltMin, c.AltMax, c.Owner, members, c.Build, c.Break, c.PvP)
+}
+
+// newClaim makes a claim of radius cells around a cell, through all altitudes
+func newClaim(planet *common.Planet, center common.CellIndex, radius int) *claim {
+	c := &claim{
+		Planet: planet.ID,
+		LatMin: common.Max(center.Lat-radius, 0),
+		LatMax: common.Min(center.Lat+radius, planet.LatCells-1),
+		AltMin: 0,
+		AltMax: planet.AltCells - 1,
+	}
+	if 2*radius+1 >= planet.LonCells {
+		c.LonMin, c.LonMax = 0, planet.LonCells-1
+	} else {
+		c.LonMin = (center.Lon - radius + planet.LonCells) % planet.LonCells
+		c.LonMax = (center.Lon + radius) % planet.LonCells
+	}
+	return c
+}
+
+// spawnClaim is the built in claim that protects a planet's spawn point
+func spawnClaim(planet *common.Planet) *claim {
+	if spawnProtection <= 0 {
+		return nil
+	}
+	spawn := planet.CartesianToCellIndex(mgl32.Vec3{float32(planet.Radius), 0, 0})
+	c := newClaim(planet, spawn, spawnProtection)
+	c.Spawn = true
+	return c
+}
+
+// claimAt returns the claim covering a cell of the world, if any
+func (w *world) claimAt(planet *common.Planet, ind common.CellIndex) *claim {
+	if spawn := spawnClaim(planet); spawn != nil && spawn.contains(planet.ID, ind) {
+		return spawn
+	}
+	w.claimsMutex.Lock()
+	defer w.claimsMutex.Unlock()
+	for _, c := range w.claims {
+		if c.contains(planet.ID, ind) {
+			return c
+		}
+	}
+	return nil
+}
+
+// checkClaim returns an error if a claim stops the person doing something at a cell
+func (p *connectedPerson) checkClaim(planet *common.Planet, ind common.CellIndex, action string) error {
+	c := p.getWorld().claimAt(planet, ind)
+	if c == nil || c.allows(action) || p.can(permAdmin) {
+		return nil
+	}
+	if action != actionPvP && c.isMember(p.getState().Name) {
+		return nil
+	}
+	return errClaimed
+}
+
+// checkClaimAt checks a claim at a position in the world
+func (p *connectedPerson) checkClaimAt(planetID int, pos mgl32.Vec3, action string) error {
+	planet := p.getWorld().planet(planetID)
+	if planet == nil {
+		return nil
+	}
+	return p.checkClaim(planet, planet.CartesianToCellIndex(pos), action)
+}
+
+// findClaim finds a claim by ID that the person may change
+func findClaim(ctx *commandContext, s string) (*claim, error) {
+	id, e := strconv.Atoi(strings.TrimPrefix(s, "#"))
+	if e != nil {
+		return nil, fmt.Errorf("bad claim ID %v", s)
+	}
+	w := ctx.world()
+	w.claimsMutex.Lock()
+	defer w.claimsMutex.Unlock()
+	for _, c := range w.claims {
+		if c.ID == id {
+			if !ctx.allowed(permAdmin) && c.Owner != ctx.person.getState().Name {
+				return nil, fmt.Errorf("claim #%v is not yours", id)
+			}
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no claim #%v", id)
+}
+
+func claimCommand(ctx *commandContext, args []string) error {
+	usage := commands["claim"].usage
+	if e := needArgs(args, 1, usage); e != nil {
+		return e
+	}
+	w := ctx.world()
+	switch args[0] {
+	case "list":
+		w.claimsMutex.Lock()
+		list := append([]*claim{}, w.claims...)
+		w.claimsMutex.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		for _, c := range list {
+			if ctx.person == nil || ctx.allowed(permAdmin) || c.isMember(ctx.person.getState().Name) {
+				ctx.reply(c.String())
+			}
+		}
+		return nil
+	case "info":
+		if ctx.person == nil {
+			return errNotPlayer
+		}
+		state := ctx.person.getState()
+		planet := w.planet(state.Planet)
+		c := w.claimAt(planet, planet.CartesianToCellIndex(state.Position.Sub(state.Position.Normalize().Mul(playerHeight))))
+		if c == nil {
+			ctx.reply("Not in a claim")
+		} else {
+			ctx.reply(c.String())
+		}
+		return nil
+	case "create":
+		return createClaim(ctx, args[1:])
+	case "delete":
+		if e := needArgs(args, 2, usage); e != nil {
+			return e
+		}
+		c, e := findClaim(ctx, args[1])
+		if e != nil {
+			return e
+		}
+		w.claimsMutex.Lock()
+		for i, o := range w.claims {
+			if o == c {
+				w.claims = append(w.claims[:i], w.claims[i+1:]...)
+				break
+			}
+		}
+		w.claimsMutex.Unlock()
+		deleteClaim(w.db, c.ID)
+		ctx.replyf("Deleted claim #%v", c.ID)
+		return nil
+	case "add", "remove":
+		if e := needArgs(args, 3, usage); e != nil {
+			return e
+		}
+		c, e := findClaim(ctx, args[1])
+		if e != nil {
+			return e
+		}
+		w.claimsMutex.Lock()
+		members := []string{}
+		for _, m := range c.Members {
+			if m != args[2] {
+				members = append(members, m)
+			}
+		}
+		if args[0] == "add" {
+			members = append(members, args[2])
+		}
+		c.Members = members
+		saveClaim(w.db, c)
+		w.claimsMutex.Unlock()
+		ctx.reply(c.String())
+		return nil
+	case "flag":
+		if e := needArgs(args, 4, usage); e != nil {
+			return e
+		}
+		c, e := findClaim(ctx, args[1])
+		if e != nil {
+			return e
+		}
+		on := args[3] == "on"
+		if !on && args[3] != "off" {
+			return errors.New("usage: " + usage)
+		}
+		w.claimsMutex.Lock()
+		switch args[2] {
+		case actionBuild:
+			c.Build = on
+		case actionBreak:
+			c.Break = on
+		case actionPvP:
+			c.PvP = on
+		default:
+			w.claimsMutex.Unlock()
+			return fmt.Errorf("unknown flag %v", args[2])
+		}
+		saveClaim(w.db, c)
+		w.claimsMutex.Unlock()
+		ctx.reply(c.String())
+		return nil
+	}
+	return errors.New("usage: " + usage)
+}
+
+// createClaim claims a radius around the player, or explicit lon and lat ranges on their planet
+func createClaim(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	usage := commands["claim"].usage
+	w := ctx.world()
+	state := ctx.person.getState()
+	planet := w.planet(state.Planet)
+	ints := []int{}
+	for _, a := range args {
+		v, e := strconv.Atoi(a)
+		if e != nil {
+			return errors.New("usage: " + usage)
+		}
+		ints = append(ints, v)
+	}
+	maxRadius := getconfigint("max_claim_radius", defaultMaxClaimRadius)
+	var c *claim
+	switch len(ints) {
+	case 1:
+		if ints[0] <= 0 {
+			return errors.New("the radius must be at least 1")
+		}
+		if ints[0] > maxRadius && !ctx.allowed(permAdmin) {
+			return fmt.Errorf("the radius can be at most %v", maxRadius)
+		}
+		feet := state.Position.Sub(state.Position.Normalize().Mul(playerHeight))
+		c = newClaim(planet, planet.CartesianToCellIndex(feet), ints[0])
+	case 4, 6:
+		c = &claim{Planet: planet.ID, LonMin: ints[0], LonMax: ints[1], LatMin: ints[2], LatMax: ints[3], AltMin: 0, AltMax: planet.AltCells - 1}
+		if len(ints) == 6 {
+			c.AltMin, c.AltMax = ints[4], ints[5]
+		}
+		if c.LonMin < 0 || c.LonMax >= planet.LonCells || c.LonMax < 0 || c.LonMin >= planet.LonCells ||
+			c.LatMin < 0 || c.LatMax >= planet.LatCells || c.LatMin > c.LatMax || c.AltMin > c.AltMax {
+			return fmt.Errorf("ranges must be within lon 0-%v and lat 0-%v", planet.LonCells-1, planet.LatCells-1)
+		}
+	default:
+		return errors.New("usage: " + usage)
+	}
+	c.Owner = state.Name
+	c.Build, c.Break, c.PvP = false, false, true
+
+	if !ctx.allowed(permAdmin) {
+		lonSize := c.LonMax - c.LonMin
+		if lonSize < 0 {
+			lonSize += planet.LonCells
+		}
+		if lonSize > 2*maxRadius || c.LatMax-c.LatMin > 2*maxRadius {
+			return fmt.Errorf("claims can be at most %v cells across", 2*maxRadius+1)
+		}
+		owned := 0
+		w.claimsMutex.Lock()
+		for _, o := range w.claims {
+			if o.Owner == state.Name {
+				owned++
+			}
+		}
+		w.claimsMutex.Unlock()
+		if owned >= getconfigint("max_claims", defaultMaxClaims) {
+			return errors.New("you have too many claims")
+		}
+		if spawn := spawnClaim(planet); spawn != nil && c.overlaps(spawn) {
+			return errors.New("too close to spawn")
+		}
+	}
+
+	w.claimsMutex.Lock()
+	defer w.claimsMutex.Unlock()
+	for _, o := range w.claims {
+		if c.overlaps(o) {
+			return fmt.Errorf("overlaps claim #%v of %v", o.ID, o.Owner)
+		}
+	}
+	saveClaim(w.db, c)
+	w.claims = append(w.claims, c)
+	ctx.reply("Created claim " + c.String())
+	return nil
+}
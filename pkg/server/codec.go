@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// gobServerCodec is the gob codec net/rpc uses by default, which it does not export
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if e := c.enc.Encode(r); e != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return e
+	}
+	if e := c.enc.Encode(body); e != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return e
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// timedCodec records how long each call takes from reading the request to writing the response
+type timedCodec struct {
+	rpc.ServerCodec
+	started map[uint64]time.Time
+	mutex   sync.Mutex
+}
+
+func newTimedCodec(codec rpc.ServerCodec) rpc.ServerCodec {
+	return &timedCodec{ServerCodec: codec, started: make(map[uint64]time.Time)}
+}
+
+func (c *timedCodec) ReadRequestHeader(r *rpc.Request) error {
+	e := c.ServerCodec.ReadRequestHeader(r)
+	if e == nil {
+		c.mutex.Lock()
+		c.started[r.Seq] = time.Now()
+		c.mutex.Unlock()
+	}
+	return e
+}
+
+func (c *timedCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	start, ok := c.started[r.Seq]
+	delete(c.started, r.Seq)
+	c.mutex.Unlock()
+	if ok {
+		observeRPC(r.ServiceMethod, time.Since(start), r.Error != "")
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// serveCodec serves the API to a client, timing calls when metrics are on
+func serveCodec(srpc *rpc.Server, codec rpc.ServerCodec) {
+	if metricsEnabled {
+		codec = newTimedCodec(codec)
+	}
+	srpc.ServeCodec(codec)
+}
+
+// Bytes moved over all client connections
+var bytesReceived, bytesSent int64
+
+// countingConn counts the bytes passing through a client connection
+type countingConn struct {
+	net.Conn
+	received, sent int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, e := c.Conn.Read(b)
+	atomic.AddInt64(&c.received, int64(n))
+	atomic.AddInt64(&bytesReceived, int64(n))
+	return n, e
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, e := c.Conn.Write(b)
+	atomic.AddInt64(&c.sent, int64(n))
+	atomic.AddInt64(&bytesSent, int64(n))
+	return n, e
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+var errNotPlayer = errors.New("only players can do that")
+
+// commandContext is who ran a command and where its output goes
+type commandContext struct {
+	// person is nil when the command comes from the console
+	person *connectedPerson
+	reply  func(text string)
+}
+
+func (ctx *commandContext) replyf(format string, args ...interface{}) {
+	ctx.reply(fmt.Sprintf(format, args...))
+}
+
+// world returns the world of whoever ran the command, or the main world from the console
+func (ctx *commandContext) world() *world {
+	if ctx.person == nil {
+		return mainWorld
+	}
+	return ctx.person.getWorld()
+}
+
+// allowed returns whether whoever ran the command has a permission
+func (ctx *commandContext) allowed(permission string) bool {
+	return ctx.person == nil || ctx.person.can(permission)
+}
+
+// command is a command that can be run from the console or as a slash command in chat
+type command struct {
+	usage      string
+	help       string
+	permission string
+	run        func(ctx *commandContext, args []string) error
+}
+
+var commands = map[string]*command{}
+
+var gameModes = map[string]int{
+	"normal":    common.Normal,
+	"flying":    common.Flying,
+	"spectator": common.Spectator,
+}
+
+func init() {
+	commands["help"] = &command{"help [command]", "Show commands or how to use one", permAnyone, helpCommand}
+	commands["list"] = &command{"list", "List connected players and where they are", permAdmin, listCommand}
+	commands["who"] = &command{"who", "List connected players", permAnyone, whoCommand}
+	commands["kick"] = &command{"kick <player>", "Disconnect a player", permAdmin, kickCommand}
+	commands["ban"] = &command{"ban <player> [reason]", "Disconnect a player and stop them logging in", permAdmin, banCommand}
+	commands["unban"] = &command{"unban <player>", "Let a banned player log in again", permAdmin, unbanCommand}
+	commands["tp"] = &command{"tp [player] <x> <y> <z> | tp [player] <target>", "Teleport a player to a position or another player", permTeleport, tpCommand}
+	commands["gamemode"] = &command{"gamemode [player] <normal|flying|spectator>", "Set a player's game mode", permGameMode, gamemodeCommand}
+	commands["spectate"] = &command{"spectate [player]", "Fly unseen, or follow a player's view", permSpectate, spectateCommand}
+	commands["give"] = &command{"give [player] <material>", "Put a material in a player's selected hotbar slot", permGive, giveCommand}
+	commands["spawn"] = &command{"spawn", "Go back to the spawn point of your planet", permAnyone, spawnCommand}
+	commands["msg"] = &command{"msg <player> <message>", "Send a private message to a player", permAnyone, msgCommand}
+	commands["reply"] = &command{"reply <message>", "Answer the last private message you got", permAnyone, replyCommand}
+	commands["channel"] = &command{"channel [global|planet|team] [message]", "Pick where your chat goes, or send one message to a channel", permAnyone, channelCommand}
+	commands["team"] = &command{"team [name|leave|invite <player>]", "Show, found, join or leave a team for team chat, or invite a player to yours", permAnyone, teamCommand}
+	commands["mute"] = &command{"mute [player]", "Hide a player's chat from you, or list who you muted", permAnyone, muteCommand}
+	commands["unmute"] = &command{"unmute <player>", "Show a muted player's chat again", permAnyone, unmuteCommand}
+	commands["broadcast"] = &command{"broadcast <message>", "Send a message to everyone", permAdmin, broadcastCommand}
+	commands["save"] = &command{"save", "Save the world", permAdmin, saveCommand}
+	commands["time"] = &command{"time [seconds]", "Show or set the universe clock", permAnyone, timeCommand}
+	commands["planet"] = &command{"planet [player] <planet>", "Move a player to a planet's spawn point", permTeleport, planetCommand}
+	commands["world"] = &command{"world [player] [world]", "List worlds or move a player to another world", permAnyone, worldCommand}
+	commands["register"] = &command{"register <player> <password>", "Create an account or reset its password, needed for names in ops or with a role", permRoles, registerCommand}
+	commands["role"] = &command{"role [player] [visitor|builder|admin|owner]", "Show or set player roles", permRoles, roleCommand}
+	commands["claim"] = &command{"claim create <radius> | claim create <lonMin> <lonMax> <latMin> <latMax> [altMin altMax] | claim list | claim info | claim delete <id> | claim add|remove <id> <player> | claim flag <id> <build|break|pvp> <on|off>", "Protect a region so only its members can build", permEdit, claimCommand}
+	commands["record"] = &command{"record [start [name]|stop]", "Record the session to a file for replay", permAdmin, recordCommand}
+	commands["stop"] = &command{"stop", "Save and stop the server", permAdmin, stopCommand}
+}
+
+// runCommand parses and runs a command line
+func runCommand(ctx *commandContext, line string) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+	cmd := commands[args[0]]
+	if cmd == nil {
+		ctx.replyf("Unknown command %v, try help", args[0])
+		return
+	}
+	if !ctx.allowed(cmd.permission) {
+		ctx.replyf("You do not have permission to use %v", args[0])
+		return
+	}
+	if e := cmd.run(ctx, args[1:]); e != nil {
+		ctx.replyf("Error: %v", e)
+	}
+}
+
+// completeCommand returns the words that can complete the last word of a partial command line
+func completeCommand(line string) []string {
+	words := strings.Fields(line)
+	if len(words) == 0 || strings.HasSuffix(line, " ") {
+		words = append(words, "")
+	}
+	last := words[len(words)-1]
+	options := []string{}
+	if len(words) == 1 {
+		for name := range commands {
+			options = append(options, name)
+		}
+	} else if words[0] == "gamemode" && len(words) >= 2 && len(words) <= 3 {
+		for name := range gameModes {
+			options = append(options, name)
+		}
+		for _, c := range people() {
+			options = append(options, c.getState().Name)
+		}
+	} else if words[0] == "planet" && len(words) >= 2 && len(words) <= 3 {
+		for _, planet := range mainWorld.universe.PlanetMap {
+			options = append(options, planet.Name)
+		}
+		for _, c := range people() {
+			options = append(options, c.getState().Name)
+		}
+	} else if words[0] == "world" && len(words) >= 2 && len(words) <= 3 {
+		for _, w := range allWorlds() {
+			options = append(options, w.name)
+		}
+		for _, c := range people() {
+			options = append(options, c.getState().Name)
+		}
+	} else if words[0] == "claim" && len(words) == 2 {
+		options = append(options, "create", "list", "info", "delete", "add", "remove", "flag")
+	} else if words[0] == "claim" && words[1] == "flag" && len(words) == 4 {
+		options = append(options, actionBuild, actionBreak, actionPvP)
+	} else if words[0] == "claim" && words[1] == "flag" && len(words) == 5 {
+		options = append(options, "on", "off")
+	} else if words[0] == "channel" && len(words) == 2 {
+		options = append(options, chatChannels...)
+	} else if words[0] == "team" && len(words) == 2 {
+		options = append(options, "leave", "invite")
+	} else if words[0] == "role" && len(words) == 3 {
+		options = append(options, roles...)
+	} else if words[0] == "give" && len(words) >= 2 && len(words) <= 3 {
+		options = append(options, common.Materials...)
+		for _, c := range people() {
+			options = append(options, c.getState().Name)
+		}
+	} else if words[0] == "help" {
+		for name := range commands {
+			options = append(options, name)
+		}
+	} else {
+		for _, c := range people() {
+			options = append(options, c.getState().Name)
+		}
+	}
+	matches := []string{}
+	for _, option := range options {
+		if strings.HasPrefix(option, last) {
+			matches = append(matches, option)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// needArgs returns an error with the usage if there are too few arguments
+func needArgs(args []string, n int, usage string) error {
+	if len(args) < n {
+		return errors.New("usage: " + usage)
+	}
+	return nil
+}
+
+// needPerson finds a connected player by name
+func needPerson(name string) (*connectedPerson, error) {
+	c := findPerson(name)
+	if c == nil {
+		return nil, fmt.Errorf("%v is not connected", name)
+	}
+	return c, nil
+}
+
+// targetPerson returns the player named by a leading argument when there are
+// more than n arguments, or else whoever ran the command
+func targetPerson(ctx *commandContext, args []string, n int) (*connectedPerson, []string, error) {
+	if len(args) > n {
+		c, e := needPerson(args[0])
+		return c, args[1:], e
+	}
+	if ctx.person == nil {
+		return nil, nil, errNotPlayer
+	}
+	return ctx.person, args, nil
+}
+
+func helpCommand(ctx *commandContext, args []string) error {
+	if len(args) > 0 {
+		cmd := commands[args[0]]
+		if cmd == nil {
+			return fmt.Errorf("unknown command %v", args[0])
+		}
+		ctx.replyf("%v - %v", cmd.usage, cmd.help)
+		return nil
+	}
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ctx.allowed(commands[name].permission) {
+			ctx.replyf("%v - %v", commands[name].usage, commands[name].help)
+		}
+	}
+	return nil
+}
+
+func listCommand(ctx *commandContext, args []string) error {
+	list := people()
+	ctx.replyf("%v players connected", len(list))
+	for _, c := range list {
+		state := c.getState()
+		mode := "normal"
+		for name, m := range gameModes {
+			if m == state.GameMode {
+				mode = name
+			}
+		}
+		pos := state.Position
+		ctx.replyf("%v in world %v on planet %v at (%.1f, %.1f, %.1f), %v", state.Name, c.getWorld().name, state.Planet, pos[0], pos[1], pos[2], mode)
+	}
+	return nil
+}
+
+func whoCommand(ctx *commandContext, args []string) error {
+	names := []string{}
+	for _, c := range people() {
+		if c.isSpectating() && !ctx.allowed(permAdmin) {
+			continue
+		}
+		names = append(names, c.getState().Name)
+	}
+	sort.Strings(names)
+	ctx.replyf("%v players: %v", len(names), strings.Join(names, ", "))
+	return nil
+}
+
+func kickCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["kick"].usage); e != nil {
+		return e
+	}
+	c, e := needPerson(args[0])
+	if e != nil {
+		return e
+	}
+	kick(c)
+	ctx.replyf("Kicked %v", args[0])
+	return nil
+}
+
+func banCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["ban"].usage); e != nil {
+		return e
+	}
+	ban(db, args[0], strings.Join(args[1:], " "))
+	if c := findPerson(args[0]); c != nil {
+		kick(c)
+	}
+	ctx.replyf("Banned %v", args[0])
+	return nil
+}
+
+func unbanCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["unban"].usage); e != nil {
+		return e
+	}
+	if !unban(db, args[0]) {
+		return fmt.Errorf("%v is not banned", args[0])
+	}
+	ctx.replyf("Unbanned %v", args[0])
+	return nil
+}
+
+func tpCommand(ctx *commandContext, args []string) error {
+	usage := commands["tp"].usage
+	if e := needArgs(args, 1, usage); e != nil {
+		return e
+	}
+	n := 1
+	if len(args) >= 3 {
+		n = 3
+	}
+	c, args, e := targetPerson(ctx, args, n)
+	if e != nil {
+		return e
+	}
+	var planet int
+	var pos mgl32.Vec3
+	if len(args) == 1 {
+		target, e := needPerson(args[0])
+		if e != nil {
+			return e
+		}
+		if e := c.moveToWorld(target.getWorld()); e != nil {
+			return e
+		}
+		state := target.getState()
+		planet = state.Planet
+		pos = state.Position
+	} else {
+		if e := needArgs(args, 3, usage); e != nil {
+			return e
+		}
+		for i := range pos {
+			v, e := strconv.ParseFloat(args[i], 32)
+			if e != nil {
+				return errors.New("usage: " + usage)
+			}
+			pos[i] = float32(v)
+		}
+		planet = c.getState().Planet
+	}
+	if e := c.teleport(planet, pos); e != nil {
+		return e
+	}
+	ctx.replyf("Teleported %v", c.getState().Name)
+	return nil
+}
+
+func gamemodeCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["gamemode"].usage); e != nil {
+		return e
+	}
+	c, args, e := targetPerson(ctx, args, 1)
+	if e != nil {
+		return e
+	}
+	mode, ok := gameModes[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown game mode %v", args[0])
+	}
+	if e := c.setGameMode(mode); e != nil {
+		return e
+	}
+	ctx.replyf("Set %v to %v", c.getState().Name, args[0])
+	return nil
+}
+
+func giveCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["give"].usage); e != nil {
+		return e
+	}
+	c, args, e := targetPerson(ctx, args, 1)
+	if e != nil {
+		return e
+	}
+	material := common.MaterialByName(args[0])
+	if n, e := strconv.Atoi(args[0]); e == nil && n > common.Air && n < len(common.Materials) {
+		material = n
+	}
+	if material <= common.Air {
+		return fmt.Errorf("unknown material %v", args[0])
+	}
+	var ret bool
+	if e := c.call("API.Give", &material, &ret); e != nil {
+		return e
+	}
+	ctx.replyf("Gave %v %v", c.getState().Name, common.Materials[material])
+	return nil
+}
+
+func spawnCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	return ctx.person.sendToSpawn(ctx.person.getState().Planet)
+}
+
+func broadcastCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["broadcast"].usage); e != nil {
+		return e
+	}
+	text := "[server] " + strings.Join(args, " ")
+	sendChat(chatMessage{Channel: chatGlobal, Text: text})
+	ctx.reply(text)
+	return nil
+}
+
+func saveCommand(ctx *commandContext, args []string) error {
+	// Cell edits are written as they happen, so only the clocks need saving
+	for _, w := range allWorlds() {
+		if e := w.universe.SaveTime(); e != nil {
+			return fmt.Errorf("could not save the clock of %v: %v", w.name, e)
+		}
+	}
+	ctx.reply("Saved")
+	return nil
+}
+
+func timeCommand(ctx *commandContext, args []string) error {
+	if len(args) > 0 {
+		if !ctx.allowed(permAdmin) {
+			return errors.New("you do not have permission to set the time")
+		}
+		seconds, e := strconv.ParseFloat(args[0], 64)
+		if e != nil {
+			return errors.New("usage: " + commands["time"].usage)
+		}
+		if e := ctx.world().universe.SetTime(seconds); e != nil {
+			return fmt.Errorf("time set but not saved: %v", e)
+		}
+	}
+	ctx.replyf("Universe time is %.0f seconds", ctx.world().universe.Time())
+	return nil
+}
+
+func planetCommand(ctx *commandContext, args []string) error {
+	if e := needArgs(args, 1, commands["planet"].usage); e != nil {
+		return e
+	}
+	c, args, e := targetPerson(ctx, args, 1)
+	if e != nil {
+		return e
+	}
+	planet := c.getWorld().findPlanet(args[0])
+	if planet == nil {
+		return fmt.Errorf("unknown planet %v", args[0])
+	}
+	if e := c.sendToSpawn(planet.ID); e != nil {
+		return e
+	}
+	ctx.replyf("Sent %v to %v", c.getState().Name, planet.Name)
+	return nil
+}
+
+func stopCommand(ctx *commandContext, args []string) error {
+	ctx.reply("Stopping")
+	stop()
+	return nil
+}
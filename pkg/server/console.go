@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var (
+	consoleEnabled bool
+	restoreConsole = func() {}
+)
+
+// EnableConsole makes Start read admin commands from stdin
+func EnableConsole() {
+	consoleEnabled = true
+}
+
+// runConsole reads commands from stdin until it closes. On a terminal it
+// offers tab completion and history.
+func runConsole() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		ctx := &commandContext{reply: func(text string) { fmt.Println(text) }}
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			runCommand(ctx, scanner.Text())
+		}
+		return
+	}
+
+	oldState, e := term.MakeRaw(fd)
+	if e != nil {
+		log.Println("Console disabled:", e)
+		return
+	}
+	restoreConsole = func() { term.Restore(fd, oldState) }
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "> ")
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' || pos != len(line) {
+			return "", 0, false
+		}
+		matches := completeCommand(line)
+		if len(matches) == 0 {
+			return "", 0, false
+		}
+		start := strings.LastIndex(line, " ") + 1
+		word := commonPrefix(matches)
+		if len(matches) == 1 {
+			word += " "
+		}
+		line = line[:start] + word
+		return line, len(line), true
+	}
+
+	// Print log lines above the prompt
+	log.SetOutput(t)
+	ctx := &commandContext{reply: func(text string) { fmt.Fprintln(t, text) }}
+	for {
+		line, e := t.ReadLine()
+		if e != nil {
+			stop()
+			return
+		}
+		runCommand(ctx, line)
+	}
+}
+
+// commonPrefix returns the longest prefix shared by all the strings
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// stop saves the world, disconnects everyone and exits
+func stop() {
+	log.Println("Stopping server")
+	for _, c := range people() {
+		kick(c)
+	}
+	// Ignore the error when there is no recording to finish
+	stopRecording()
+	for _, w := range allWorlds() {
+		if e := w.universe.SaveTime(); e != nil {
+			log.Printf("Clock save error in %v: %v", w.name, e)
+		}
+		w.db.Close()
+	}
+	restoreConsole()
+	os.Exit(0)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// announceInterval is how often the server tells the local network it is there
+const announceInterval = 2 * time.Second
+
+// announce multicasts the server's name, worlds, player count, port and whether
+// it uses TLS on the local network until the process exits. Set lan_announce=0 to turn it off.
+func announce(world string, port int) {
+	if getconfigint("lan_announce", 1) == 0 {
+		return
+	}
+	name := getconfig("server_name")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	conn, e := net.DialUDP("udp4", nil, common.DiscoveryAddress)
+	if e != nil {
+		log.Println("LAN announce disabled:", e)
+		return
+	}
+	defer conn.Close()
+	for {
+		worlds := []string{world}
+		for _, w := range allWorlds() {
+			if w.name != world {
+				worlds = append(worlds, w.name)
+			}
+		}
+		data, e := common.EncodeAnnouncement(common.ServerAnnouncement{
+			Name:    name,
+			World:   world,
+			Worlds:  worlds,
+			Players: len(people()),
+			Port:    port,
+			TLS:     serverTLS != nil,
+		})
+		if e != nil {
+			panic(e)
+		}
+		conn.Write(data)
+		time.Sleep(announceInterval)
+	}
+}
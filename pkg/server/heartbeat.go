@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"time"
+)
+
+// Default heartbeat settings in seconds, overridable with the heartbeat_interval
+// and heartbeat_timeout keys in server.buildorb
+const (
+	defaultHeartbeatInterval = 5
+	defaultHeartbeatTimeout  = 15
+)
+
+var (
+	errTimeout     = errors.New("call timed out")
+	errNoCallbacks = errors.New("client does not take calls")
+)
+
+func heartbeatInterval() time.Duration {
+	return time.Duration(getconfigint("heartbeat_interval", defaultHeartbeatInterval)) * time.Second
+}
+
+func heartbeatTimeout() time.Duration {
+	return time.Duration(getconfigint("heartbeat_timeout", defaultHeartbeatTimeout)) * time.Second
+}
+
+// sleep waits for a duration, returning false early if the person disconnected
+func (c *connectedPerson) sleep(d time.Duration) bool {
+	select {
+	case <-c.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// call calls an API method on the person's client, giving up after the heartbeat
+// timeout read when they connected
+func (c *connectedPerson) call(method string, args interface{}, reply interface{}) error {
+	if c.rpc == nil {
+		return errNoCallbacks
+	}
+	call := c.rpc.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-c.done:
+		return rpc.ErrShutdown
+	case <-time.After(c.callTimeout):
+		return errTimeout
+	}
+}
+
+// heartbeat pings the person's client until it stops answering, then drops them
+func (c *connectedPerson) heartbeat() {
+	if c.rpc == nil {
+		return
+	}
+	interval := heartbeatInterval()
+	for c.sleep(interval) {
+		var ret bool
+		e := c.call("API.Ping", 0, &ret)
+		if e != nil {
+			log.Printf("Heartbeat to %v failed: %v", c.getState().Name, e)
+			c.disconnect()
+			return
+		}
+	}
+}
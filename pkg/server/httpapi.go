@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+var (
+	serverPort int
+	startTime  = time.Now()
+)
+
+type serverInfo struct {
+	World   string
+	Worlds  []string
+	Port    int
+	Players int
+	Planets int
+	Time    float64
+	Uptime  float64
+}
+
+type playerInfo struct {
+	Name     string
+	World    string
+	Planet   int
+	Position mgl32.Vec3
+	GameMode int
+}
+
+type planetInfo struct {
+	World           string
+	ID              int
+	Name            string
+	Radius          float64
+	OrbitPlanet     int
+	OrbitDistance   float64
+	OrbitSeconds    float64
+	RotationSeconds float64
+	LoadedChunks    int
+}
+
+type adminRequest struct {
+	Player  string
+	Message string
+}
+
+// serveAdminAPI serves a JSON status API on localhost. Endpoints that change
+// anything need the admin_token from the config as a bearer token.
+func serveAdminAPI(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/info", getOnly(func() interface{} {
+		names := []string{}
+		for _, w := range allWorlds() {
+			names = append(names, w.name)
+		}
+		return serverInfo{
+			World:   mainWorld.name,
+			Worlds:  names,
+			Port:    serverPort,
+			Players: len(people()),
+			Planets: len(mainWorld.universe.PlanetMap),
+			Time:    mainWorld.universe.Time(),
+			Uptime:  time.Since(startTime).Seconds(),
+		}
+	}))
+	mux.HandleFunc("/api/players", getOnly(func() interface{} {
+		players := []playerInfo{}
+		for _, c := range people() {
+			state := c.getState()
+			players = append(players, playerInfo{state.Name, c.getWorld().name, state.Planet, state.Position, state.GameMode})
+		}
+		return players
+	}))
+	mux.HandleFunc("/api/planets", getOnly(func() interface{} {
+		planets := []planetInfo{}
+		for _, w := range allWorlds() {
+			for _, planet := range w.universe.PlanetMap {
+				planet.ChunksMutex.Lock()
+				loaded := len(planet.Chunks)
+				planet.ChunksMutex.Unlock()
+				planets = append(planets, planetInfo{
+					World:           w.name,
+					ID:              planet.ID,
+					Name:            planet.Name,
+					Radius:          planet.Radius,
+					OrbitPlanet:     planet.OrbitPlanet,
+					OrbitDistance:   planet.OrbitDistance,
+					OrbitSeconds:    planet.OrbitSeconds,
+					RotationSeconds: planet.RotationSeconds,
+					LoadedChunks:    loaded,
+				})
+			}
+		}
+		sort.Slice(planets, func(i, j int) bool {
+			if planets[i].World != planets[j].World {
+				return planets[i].World < planets[j].World
+			}
+			return planets[i].ID < planets[j].ID
+		})
+		return planets
+	}))
+	mux.HandleFunc("/api/chat", getOnly(func() interface{} {
+		return chatHistory()
+	}))
+	mux.HandleFunc("/api/kick", adminOnly(func(req adminRequest) []string {
+		return []string{req.Player}
+	}, kickCommand))
+	mux.HandleFunc("/api/broadcast", adminOnly(func(req adminRequest) []string {
+		return strings.Fields(req.Message)
+	}, broadcastCommand))
+	mux.HandleFunc("/api/save", adminOnly(func(req adminRequest) []string {
+		return nil
+	}, saveCommand))
+
+	log.Printf("Admin API listening on localhost:%v...\n", port)
+	log.Fatal("Admin API listen error:", http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), mux))
+}
+
+// writeJSON writes a value as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// getOnly serves the result of a function for GET requests
+func getOnly(get func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"Error": "use GET"})
+			return
+		}
+		writeJSON(w, http.StatusOK, get())
+	}
+}
+
+// adminOnly runs a console command for an authorized POST request with a JSON body
+func adminOnly(args func(adminRequest) []string, run func(*commandContext, []string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"Error": "use POST"})
+			return
+		}
+		token := getconfig("admin_token")
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"Error": "bad admin token"})
+			return
+		}
+		var req adminRequest
+		if r.ContentLength != 0 {
+			if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"Error": e.Error()})
+				return
+			}
+		}
+		output := []string{}
+		ctx := &commandContext{reply: func(text string) { output = append(output, text) }}
+		if e := run(ctx, args(req)); e != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": e.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]string{"Output": output})
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// latencyBuckets are the upper bounds in seconds of the RPC latency histogram
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type rpcMetric struct {
+	calls   uint64
+	errors  uint64
+	buckets []uint64
+	sum     float64
+}
+
+var (
+	metricsEnabled  bool
+	rpcMetrics      = map[string]*rpcMetric{}
+	rpcMetricsMutex = &sync.Mutex{}
+	// broadcastQueue is how many calls are waiting in people's send queues
+	broadcastQueue int64
+	chunksStreamed int64
+)
+
+// observeRPC records one served call
+func observeRPC(method string, d time.Duration, failed bool) {
+	rpcMetricsMutex.Lock()
+	defer rpcMetricsMutex.Unlock()
+	m := rpcMetrics[method]
+	if m == nil {
+		m = &rpcMetric{buckets: make([]uint64, len(latencyBuckets))}
+		rpcMetrics[method] = m
+	}
+	m.calls++
+	if failed {
+		m.errors++
+	}
+	seconds := d.Seconds()
+	m.sum += seconds
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			m.buckets[i]++
+		}
+	}
+}
+
+// serveMetrics serves Prometheus metrics at /metrics
+func serveMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	log.Printf("Metrics listening on port %v...\n", port)
+	log.Fatal("Metrics listen error:", http.ListenAndServe(fmt.Sprintf(":%v", port), mux))
+}
+
+// metric writes the header and samples of one metric
+func metric(w io.Writer, name, kind, help string, samples ...string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%v%v\n", name, s)
+	}
+}
+
+func value(v interface{}) string {
+	return fmt.Sprintf(" %v", v)
+}
+
+func labeled(labels string, v interface{}) string {
+	return fmt.Sprintf("{%v} %v", labels, v)
+}
+
+func label(name, v string) string {
+	return name + "=" + strconv.Quote(v)
+}
+
+// writeMetrics writes all metrics in the Prometheus text format
+func writeMetrics(w io.Writer) {
+	list := people()
+	metric(w, "buildorb_players", "gauge", "Connected players.", value(len(list)))
+
+	received := []string{}
+	sent := []string{}
+	for _, c := range list {
+		if c.traffic == nil {
+			continue
+		}
+		player := label("player", c.getState().Name)
+		received = append(received, labeled(player, atomic.LoadInt64(&c.traffic.received)))
+		sent = append(sent, labeled(player, atomic.LoadInt64(&c.traffic.sent)))
+	}
+	metric(w, "buildorb_client_received_bytes_total", "counter", "Bytes received from each connected client.", received...)
+	metric(w, "buildorb_client_sent_bytes_total", "counter", "Bytes sent to each connected client.", sent...)
+	metric(w, "buildorb_received_bytes_total", "counter", "Bytes received from all clients.", value(atomic.LoadInt64(&bytesReceived)))
+	metric(w, "buildorb_sent_bytes_total", "counter", "Bytes sent to all clients.", value(atomic.LoadInt64(&bytesSent)))
+
+	rpcMetricsMutex.Lock()
+	methods := []string{}
+	for method := range rpcMetrics {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	calls := []string{}
+	errors := []string{}
+	fmt.Fprintf(w, "# HELP buildorb_rpc_duration_seconds Time to serve RPC calls.\n# TYPE buildorb_rpc_duration_seconds histogram\n")
+	for _, method := range methods {
+		m := rpcMetrics[method]
+		l := label("method", method)
+		calls = append(calls, labeled(l, m.calls))
+		errors = append(errors, labeled(l, m.errors))
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "buildorb_rpc_duration_seconds_bucket{%v,le=\"%v\"} %v\n", l, le, m.buckets[i])
+		}
+		fmt.Fprintf(w, "buildorb_rpc_duration_seconds_bucket{%v,le=\"+Inf\"} %v\n", l, m.calls)
+		fmt.Fprintf(w, "buildorb_rpc_duration_seconds_sum{%v} %v\n", l, m.sum)
+		fmt.Fprintf(w, "buildorb_rpc_duration_seconds_count{%v} %v\n", l, m.calls)
+	}
+	rpcMetricsMutex.Unlock()
+	metric(w, "buildorb_rpc_calls_total", "counter", "RPC calls served.", calls...)
+	metric(w, "buildorb_rpc_errors_total", "counter", "RPC calls that returned an error.", errors...)
+	metric(w, "buildorb_broadcast_queue_depth", "gauge", "Calls waiting in players' send queues.", value(atomic.LoadInt64(&broadcastQueue)))
+
+	inMemory := 0
+	for _, w := range allWorlds() {
+		for _, planet := range w.universe.PlanetMap {
+			planet.ChunksMutex.Lock()
+			inMemory += len(planet.Chunks)
+			planet.ChunksMutex.Unlock()
+		}
+	}
+	metric(w, "buildorb_chunks_in_memory", "gauge", "Chunks held in memory.", value(inMemory))
+	metric(w, "buildorb_chunks_loaded_total", "counter", "Chunks loaded from the database.", value(atomic.LoadInt64(&common.ChunkStats.Loaded)))
+	metric(w, "buildorb_chunks_generated_total", "counter", "Chunks generated for the first time.", value(atomic.LoadInt64(&common.ChunkStats.Generated)))
+	metric(w, "buildorb_chunks_persisted_total", "counter", "Chunk writes to the database.", value(atomic.LoadInt64(&common.ChunkStats.Persisted)))
+	metric(w, "buildorb_chunks_streamed_total", "counter", "Chunks streamed to clients.", value(atomic.LoadInt64(&chunksStreamed)))
+	metric(w, "buildorb_db_write_seconds_total", "counter", "Time spent writing chunks to the database.", value(float64(atomic.LoadInt64(&common.ChunkStats.DBWriteNanos))/1e9))
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	cpu := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(cpu)
+	metric(w, "go_cpu_seconds_total", "counter", "Estimated CPU time used by the server.", value(cpu[0].Value.Float64()-cpu[1].Value.Float64()))
+	metric(w, "go_goroutines", "gauge", "Number of goroutines.", value(runtime.NumGoroutine()))
+	metric(w, "go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", value(mem.HeapAlloc))
+	metric(w, "go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", value(mem.Sys))
+	metric(w, "go_gc_cycles_total", "counter", "Completed GC cycles.", value(mem.NumGC))
+	metric(w, "go_gc_pause_seconds_total", "counter", "Total GC pause time.", value(float64(mem.PauseTotalNs)/1e9))
+}
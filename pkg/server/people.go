@@ -0,0 +1,243 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Interest management settings
+const (
+	// viewRadius is the distance within which players get every update about each other
+	viewRadius = 128
+	// farUpdateInterval is how often players outside the view radius get a summary of each other
+	farUpdateInterval = 2 * time.Second
+)
+
+var (
+	connectedPeople []*connectedPerson
+	peopleMutex     = &sync.Mutex{}
+)
+
+type connectedPerson struct {
+	rpc            *rpc.Client
+	session        io.Closer
+	traffic        *countingConn
+	loggedIn       bool
+	role           string
+	world          *world
+	switchingWorld bool
+	spectateReturn *spectateReturn
+	team           string
+	chatChannel    string
+	lastWhisper    string
+	muted          map[string]bool
+	state          common.PlayerState
+	chunks         map[common.PlanetChunkIndex]bool
+	lastFarUpdate  map[string]time.Time
+	streaming      bool
+	editLimit      *tokenBucket
+	lastMove       time.Time
+	lastCorrection time.Time
+	airborneSince  time.Time
+	spawnPlanet    int
+	sendQueue      chan outgoing
+	callTimeout    time.Duration
+	done           chan struct{}
+	closeOnce      *sync.Once
+	mutex          *sync.Mutex
+}
+
+func newConnectedPerson() *connectedPerson {
+	p := connectedPerson{}
+	p.chunks = make(map[common.PlanetChunkIndex]bool)
+	p.lastFarUpdate = make(map[string]time.Time)
+	p.spawnPlanet = -1
+	p.chatChannel = chatGlobal
+	p.muted = make(map[string]bool)
+	p.mutex = &sync.Mutex{}
+	p.done = make(chan struct{})
+	p.sendQueue = newSendQueue()
+	p.callTimeout = heartbeatTimeout()
+	p.closeOnce = &sync.Once{}
+	p.editLimit = newTokenBucket(float64(getconfigint("edit_rate", defaultEditRate)), defaultEditBurst)
+	return &p
+}
+
+func (c *connectedPerson) getState() common.PlayerState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+func (c *connectedPerson) getWorld() *world {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.world
+}
+
+func (c *connectedPerson) setState(state common.PlayerState) {
+	c.mutex.Lock()
+	c.state = state
+	c.mutex.Unlock()
+}
+
+// teleport moves the person and tells their client, resetting the movement checks
+func (c *connectedPerson) teleport(planet int, pos mgl32.Vec3) error {
+	c.mutex.Lock()
+	c.state.Planet = planet
+	c.state.Position = pos
+	c.lastMove = time.Now()
+	c.airborneSince = time.Time{}
+	c.mutex.Unlock()
+	var ret bool
+	return c.call("API.Teleport", &common.TeleportArgs{Planet: planet, Position: pos}, &ret)
+}
+
+// sendToSpawn moves the person to the spawn point of a planet in their world,
+// remembering the planet in spawnPlanet until their client lands there.
+// Their client picks the landing spot, so any position in the spawn column is
+// accepted from them once.
+func (c *connectedPerson) sendToSpawn(planet int) error {
+	c.mutex.Lock()
+	c.spawnPlanet = planet
+	c.mutex.Unlock()
+	var ret bool
+	return c.call("API.SetPlanet", &planet, &ret)
+}
+
+// tell sends a chat line to just this person
+func (c *connectedPerson) tell(text string) {
+	c.send("API.SendText", &text)
+}
+
+// addChunk records that a chunk has been sent to the person
+func (c *connectedPerson) addChunk(ind common.PlanetChunkIndex) {
+	c.mutex.Lock()
+	c.chunks[ind] = true
+	c.mutex.Unlock()
+}
+
+// pruneChunks forgets the chunks sent to the person that are not in a set
+func (c *connectedPerson) pruneChunks(keep map[common.PlanetChunkIndex]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for ind := range c.chunks {
+		if !keep[ind] {
+			delete(c.chunks, ind)
+		}
+	}
+}
+
+// hasChunk returns whether a chunk has been sent to the person
+func (c *connectedPerson) hasChunk(ind common.PlanetChunkIndex) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.chunks[ind]
+}
+
+// wantsPlayerUpdate returns whether the person should be sent another player's
+// full state, which is only for players on the same planet within the view radius
+func (c *connectedPerson) wantsPlayerUpdate(state *common.PlayerState) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state.Planet == state.Planet && c.state.Position.Sub(state.Position).Len() <= viewRadius {
+		c.lastFarUpdate[state.Name] = time.Now()
+		return true
+	}
+	return false
+}
+
+// wantsPlayerSummary returns whether the person should be sent a summary of a
+// far player now, which happens every farUpdateInterval
+func (c *connectedPerson) wantsPlayerSummary(state *common.PlayerState) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state.Planet == state.Planet && c.state.Position.Sub(state.Position).Len() <= viewRadius {
+		return false
+	}
+	if time.Since(c.lastFarUpdate[state.Name]) < farUpdateInterval {
+		return false
+	}
+	c.lastFarUpdate[state.Name] = time.Now()
+	return true
+}
+
+func addPerson(p *connectedPerson) {
+	peopleMutex.Lock()
+	connectedPeople = append(connectedPeople, p)
+	peopleMutex.Unlock()
+}
+
+// findPerson returns the connected person with a name, or nil
+func findPerson(name string) *connectedPerson {
+	for _, c := range people() {
+		if c.getState().Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// kick closes a person's connection and removes them from the game
+func kick(p *connectedPerson) {
+	p.disconnect()
+}
+
+// people returns a snapshot of the connected people
+func people() []*connectedPerson {
+	peopleMutex.Lock()
+	defer peopleMutex.Unlock()
+	return append([]*connectedPerson{}, connectedPeople...)
+}
+
+// removePerson removes a person, returning false if they were already gone
+func removePerson(p *connectedPerson) bool {
+	peopleMutex.Lock()
+	defer peopleMutex.Unlock()
+	for i, c := range connectedPeople {
+		if c == p {
+			connectedPeople = append(connectedPeople[:i], connectedPeople[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// broadcast queues an API call to every connected person passing the filter
+func broadcast(method string, args interface{}, filter func(c *connectedPerson) bool) {
+	for _, c := range people() {
+		if filter == nil || filter(c) {
+			c.send(method, args)
+		}
+	}
+}
+
+// disconnect stops the person's goroutines, closes their connection and tells everyone they left
+func (c *connectedPerson) disconnect() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.session.Close()
+		if c.rpc != nil {
+			c.rpc.Close()
+		}
+		personDisconnected(c)
+	})
+}
+
+func personDisconnected(p *connectedPerson) {
+	if !removePerson(p) {
+		return
+	}
+	state := p.getState()
+	name := state.Name
+	p.getWorld().savePlayer(state)
+	record(p.getWorld(), common.RecordEvent{Kind: common.RecordLeave, Name: name})
+	log.Printf("%v disconnected", name)
+	broadcast("API.PersonDisconnected", name, nil)
+}
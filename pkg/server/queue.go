@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log"
+	"net/rpc"
+	"sync/atomic"
+)
+
+// defaultSendQueue is how many calls can wait to be sent to one person,
+// overridable with the send_queue key in server.buildorb. Each person has their
+// own queue so a slow client only holds up itself.
+const defaultSendQueue = 1024
+
+// outgoing is a call waiting in a person's send queue
+type outgoing struct {
+	method string
+	args   interface{}
+}
+
+func newSendQueue() chan outgoing {
+	return make(chan outgoing, getconfigint("send_queue", defaultSendQueue))
+}
+
+// send queues an API call to the person's client without waiting for it. A
+// person whose queue is full has stopped keeping up and is disconnected.
+func (c *connectedPerson) send(method string, args interface{}) {
+	if c.rpc == nil {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	atomic.AddInt64(&broadcastQueue, 1)
+	select {
+	case c.sendQueue <- outgoing{method, args}:
+	default:
+		atomic.AddInt64(&broadcastQueue, -1)
+		log.Printf("Send queue full for %v, disconnecting", c.getState().Name)
+		go c.disconnect()
+	}
+}
+
+// sendLoop makes the queued calls until the person disconnects. Each call is
+// answered before the next is made, since the client serves calls that arrive
+// together in any order and chat lines must stay in order.
+func (c *connectedPerson) sendLoop() {
+	for {
+		select {
+		case <-c.done:
+			for {
+				select {
+				case <-c.sendQueue:
+					atomic.AddInt64(&broadcastQueue, -1)
+				default:
+					return
+				}
+			}
+		case out := <-c.sendQueue:
+			atomic.AddInt64(&broadcastQueue, -1)
+			var ret bool
+			e := c.call(out.method, out.args, &ret)
+			if e == rpc.ErrShutdown {
+				c.disconnect()
+				continue
+			}
+			if e == errTimeout {
+				log.Printf("%v to %v timed out, disconnecting", out.method, c.getState().Name)
+				c.disconnect()
+				continue
+			}
+			if e != nil {
+				log.Printf("%v error: %v", out.method, e)
+			}
+		}
+	}
+}
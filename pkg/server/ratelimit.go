@@ -0,0 +1,89 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of some quantity while allowing short bursts
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  *sync.Mutex
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	b := tokenBucket{}
+	b.rate = rate
+	b.burst = burst
+	b.tokens = burst
+	b.last = time.Now()
+	b.mutex = &sync.Mutex{}
+	return &b
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += b.rate * now.Sub(b.last).Seconds()
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow takes n tokens if they are available
+func (b *tokenBucket) allow(n float64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// give returns n tokens to the bucket, up to the burst
+func (b *tokenBucket) give(n float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	b.tokens = math.Min(b.tokens+n, b.burst)
+}
+
+// available returns whether n tokens could be taken, without taking them
+func (b *tokenBucket) available(n float64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	return b.tokens >= n
+}
+
+// charge takes n tokens if they are available. Costs bigger than the burst
+// could never be, so they only need a full bucket and leave it in debt, which
+// later calls wait out.
+func (b *tokenBucket) charge(n float64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	if b.tokens < math.Min(n, b.burst) {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// wait takes n tokens, sleeping until the bucket is no longer in debt
+func (b *tokenBucket) wait(n float64) {
+	b.mutex.Lock()
+	b.refill()
+	b.tokens -= n
+	debt := -b.tokens
+	b.mutex.Unlock()
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / b.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// recordFlushInterval is how often recorded events are written out to the file
+const recordFlushInterval = time.Second
+
+// recorder writes session events to a recording file
+type recorder struct {
+	path      string
+	file      *os.File
+	buf       *bufio.Writer
+	enc       *gob.Encoder
+	start     time.Time
+	lastFlush time.Time
+	events    int
+}
+
+var (
+	activeRecorder *recorder
+	recorderMutex  = &sync.Mutex{}
+)
+
+// snapshotWorld copies a world's database, leaving out accounts, bans, roles and chat
+func snapshotWorld(w *world) ([]byte, error) {
+	file := filepath.Join(os.TempDir(), fmt.Sprintf("buildorb-%v-%v.db", w.name, time.Now().UnixNano()))
+	defer os.Remove(file)
+	if _, e := w.db.Exec("VACUUM INTO ?", file); e != nil {
+		return nil, e
+	}
+	sdb, e := sql.Open("sqlite3", file)
+	if e != nil {
+		return nil, e
+	}
+	for _, table := range []string{"account", "ban", "role", "chat", "team", "mute"} {
+		if _, e := sdb.Exec("DROP TABLE IF EXISTS " + table); e != nil {
+			sdb.Close()
+			return nil, e
+		}
+	}
+	if _, e := sdb.Exec("VACUUM"); e != nil {
+		sdb.Close()
+		return nil, e
+	}
+	sdb.Close()
+	return ioutil.ReadFile(file)
+}
+
+// startRecording snapshots every world and records events to recordings/<path>.rec,
+// or to a file named after the current time if path is empty
+func startRecording(path string) error {
+	recorderMutex.Lock()
+	defer recorderMutex.Unlock()
+	if activeRecorder != nil {
+		return errors.New("already recording to " + activeRecorder.path)
+	}
+	if path == "" {
+		path = time.Now().Format("2006-01-02-150405")
+	}
+	path = filepath.Join("recordings", path+".rec")
+	if e := os.MkdirAll("recordings", 0755); e != nil {
+		return e
+	}
+
+	header := common.RecordHeader{Started: time.Now()}
+	for _, w := range allWorlds() {
+		data, e := snapshotWorld(w)
+		if e != nil {
+			return e
+		}
+		header.Worlds = append(header.Worlds, common.RecordedWorld{Name: w.name, Database: data})
+	}
+	file, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	r := &recorder{path: path, file: file, buf: bufio.NewWriter(file), start: time.Now(), lastFlush: time.Now()}
+	r.enc = gob.NewEncoder(r.buf)
+	if e := r.enc.Encode(&header); e != nil {
+		file.Close()
+		return e
+	}
+	activeRecorder = r
+
+	// Everyone already connected joins at the start of the recording
+	for _, c := range people() {
+		w := c.getWorld()
+		state := c.getState()
+		r.write(w, common.RecordEvent{Kind: common.RecordJoin, Name: state.Name})
+		if !c.isSpectating() {
+			r.write(w, common.RecordEvent{Kind: common.RecordState, Name: state.Name, State: state})
+		}
+	}
+	log.Printf("Recording to %v", path)
+	return nil
+}
+
+// stopRecording finishes the recording file
+func stopRecording() error {
+	recorderMutex.Lock()
+	defer recorderMutex.Unlock()
+	r := activeRecorder
+	if r == nil {
+		return errors.New("not recording")
+	}
+	activeRecorder = nil
+	if e := r.buf.Flush(); e != nil {
+		r.file.Close()
+		return e
+	}
+	log.Printf("Recorded %v events to %v", r.events, r.path)
+	return r.file.Close()
+}
+
+// write stamps and encodes an event. The caller holds recorderMutex.
+func (r *recorder) write(w *world, event common.RecordEvent) {
+	event.Elapsed = time.Since(r.start).Seconds()
+	if w != nil {
+		event.World = w.name
+		event.Time = w.universe.Time()
+	}
+	if e := r.enc.Encode(&event); e != nil {
+		log.Println("Recording error:", e)
+		return
+	}
+	r.events++
+	if time.Since(r.lastFlush) > recordFlushInterval {
+		r.lastFlush = time.Now()
+		if e := r.buf.Flush(); e != nil {
+			log.Println("Recording error:", e)
+		}
+	}
+}
+
+// record adds an event in a world to the recording, if there is one
+func record(w *world, event common.RecordEvent) {
+	recorderMutex.Lock()
+	defer recorderMutex.Unlock()
+	if activeRecorder != nil {
+		activeRecorder.write(w, event)
+	}
+}
+
+func recordCommand(ctx *commandContext, args []string) error {
+	if len(args) == 0 {
+		recorderMutex.Lock()
+		defer recorderMutex.Unlock()
+		if activeRecorder == nil {
+			ctx.reply("Not recording")
+		} else {
+			ctx.replyf("Recording to %v, %v events in %.0f seconds", activeRecorder.path, activeRecorder.events, time.Since(activeRecorder.start).Seconds())
+		}
+		return nil
+	}
+	switch args[0] {
+	case "start":
+		name := ""
+		if len(args) > 1 {
+			name = filepath.Base(args[1])
+		}
+		if e := startRecording(name); e != nil {
+			return e
+		}
+		ctx.reply("Recording started")
+		return nil
+	case "stop":
+		if e := stopRecording(); e != nil {
+			return e
+		}
+		ctx.reply("Recording stopped")
+		return nil
+	}
+	return errors.New("usage: " + commands["record"].usage)
+}
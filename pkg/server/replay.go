@@ -0,0 +1,349 @@
+package server
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// replayTick is how often playback advances
+const replayTick = 50 * time.Millisecond
+
+// replay plays a recorded session back to connected spectators
+type replay struct {
+	events []common.RecordEvent
+	// next is the index of the next event to apply
+	next int
+	// elapsed is the playback position in seconds since the recording started
+	elapsed float64
+	speed   float64
+	paused  bool
+	// players holds the latest state of each recorded player present at the playback position
+	players map[string]common.RecordEvent
+	// outbox holds what to send viewers, queued while holding the mutex and run by flush after
+	outbox []func()
+	mutex  *sync.Mutex
+}
+
+// replaying is the replay being served, if this server is a replay viewer
+var replaying *replay
+
+// Replay serves a session recording on a port. Players who connect watch as
+// spectators, and any of them may control playback with the replay command,
+// since the replay's accounts and roles start out empty.
+func Replay(path string, port int) {
+	if port == 0 {
+		port = 5555
+	}
+	serverPort = port
+	file, e := os.Open(path)
+	checkErr(e)
+	dec := gob.NewDecoder(file)
+	var header common.RecordHeader
+	checkErr(dec.Decode(&header))
+	if len(header.Worlds) == 0 {
+		panic("recording has no worlds")
+	}
+
+	// Replays change the world, so play them on copies of the snapshots
+	dir, e := ioutil.TempDir("", "buildorb-replay")
+	checkErr(e)
+	for i, rw := range header.Worlds {
+		dbFile := filepath.Join(dir, rw.Name+".db")
+		checkErr(ioutil.WriteFile(dbFile, rw.Database, 0644))
+		w := openWorldFile(rw.Name, dbFile)
+		if i == 0 {
+			mainWorld = w
+		}
+	}
+	db = mainWorld.db
+	createAccountTable(db)
+	createBanTable(db)
+	createRoleTable(db)
+	createChatTables(db)
+
+	r := &replay{speed: 1, paused: true, players: make(map[string]common.RecordEvent), mutex: &sync.Mutex{}}
+	for {
+		var event common.RecordEvent
+		e := dec.Decode(&event)
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			break
+		}
+		checkErr(e)
+		r.events = append(r.events, event)
+	}
+	file.Close()
+	replaying = r
+	commands["replay"] = &command{"replay [play|pause|speed <factor>|seek <seconds>|seek +<seconds>|seek -<seconds>]", "Control playback of the recording", permAnyone, replayCommand}
+	log.Printf("Replaying %v events over %.0f seconds from %v", len(r.events), r.length(), header.Started.Format(time.RFC1123))
+
+	listener, e := listen(port)
+	if e != nil {
+		log.Fatal("listen error:", e)
+	}
+	log.Printf("Replay listening on port %v, paused until someone runs replay play...\n", port)
+	if consoleEnabled {
+		go runConsole()
+	}
+	go r.play()
+	serve(listener)
+}
+
+// length returns the length of the recording in seconds
+func (r *replay) length() float64 {
+	if len(r.events) == 0 {
+		return 0
+	}
+	return r.events[len(r.events)-1].Elapsed
+}
+
+// play advances playback in real time scaled by the speed
+func (r *replay) play() {
+	for {
+		time.Sleep(replayTick)
+		r.mutex.Lock()
+		if !r.paused {
+			r.advance(r.elapsed+replayTick.Seconds()*r.speed, true)
+			if r.next >= len(r.events) {
+				r.paused = true
+				text := "[replay] End of recording"
+				r.queue(func() { broadcast("API.SendText", &text, nil) })
+			}
+		}
+		r.mutex.Unlock()
+		r.flush()
+	}
+}
+
+// queue adds a send to viewers to the outbox. The caller holds the mutex.
+func (r *replay) queue(send func()) {
+	r.outbox = append(r.outbox, send)
+}
+
+// flush runs the queued sends to viewers, outside the mutex so slow sends do not hold up playback
+func (r *replay) flush() {
+	r.mutex.Lock()
+	outbox := r.outbox
+	r.outbox = nil
+	r.mutex.Unlock()
+	for _, send := range outbox {
+		send()
+	}
+}
+
+// advance applies events up to a playback position. Player movement and chat
+// are only sent to viewers when live. The caller holds the mutex.
+func (r *replay) advance(target float64, live bool) {
+	start := r.next
+	for r.next < len(r.events) && r.events[r.next].Elapsed <= target {
+		r.apply(r.events[r.next], live)
+		r.next++
+	}
+	r.elapsed = target
+	r.setClocks(r.events[start:r.next])
+}
+
+// setClocks sets the world clocks from the last of the events in each world,
+// so planets are where they were at the playback position. The caller holds the mutex.
+func (r *replay) setClocks(events []common.RecordEvent) {
+	latest := make(map[string]float64)
+	for _, event := range events {
+		if event.World != "" {
+			latest[event.World] = event.Time + r.elapsed - event.Elapsed
+		}
+	}
+	for name, seconds := range latest {
+		w := findWorld(name)
+		if w == nil {
+			continue
+		}
+		seconds := seconds
+		r.queue(func() {
+			if e := w.universe.SetTime(seconds); e != nil {
+				log.Printf("Clock save error in %v: %v", w.name, e)
+			}
+		})
+	}
+}
+
+// apply plays one event forward
+func (r *replay) apply(event common.RecordEvent, live bool) {
+	switch event.Kind {
+	case common.RecordState:
+		r.players[event.Name] = event
+		if live {
+			r.queue(func() { sendReplayState(event) })
+		}
+	case common.RecordEdit:
+		r.setCell(event, event.Edit.Material)
+	case common.RecordChat:
+		if live {
+			text := event.Text
+			r.queue(func() { broadcast("API.SendText", &text, nil) })
+		}
+	case common.RecordLeave:
+		if p, ok := r.players[event.Name]; ok && p.World == event.World {
+			delete(r.players, event.Name)
+			if live {
+				r.queue(func() { sendReplayLeave(event) })
+			}
+		}
+	}
+}
+
+// seek jumps to a playback position, undoing edits when going back. The caller holds the mutex.
+func (r *replay) seek(target float64) {
+	if target < 0 {
+		target = 0
+	}
+	before := r.players
+	if target >= r.elapsed {
+		r.advance(target, false)
+	} else {
+		for r.next > 0 && r.events[r.next-1].Elapsed > target {
+			r.next--
+			if event := r.events[r.next]; event.Kind == common.RecordEdit {
+				r.setCell(event, event.Previous)
+			}
+		}
+		r.elapsed = target
+		r.players = make(map[string]common.RecordEvent)
+		for _, event := range r.events[:r.next] {
+			switch event.Kind {
+			case common.RecordState:
+				r.players[event.Name] = event
+			case common.RecordLeave:
+				if p, ok := r.players[event.Name]; ok && p.World == event.World {
+					delete(r.players, event.Name)
+				}
+			}
+		}
+		// Worlds with nothing recorded yet take their clock from their first event
+		clocks := []common.RecordEvent{}
+		seen := make(map[string]bool)
+		for _, event := range r.events[r.next:] {
+			if event.World != "" && !seen[event.World] {
+				seen[event.World] = true
+				clocks = append(clocks, event)
+			}
+		}
+		r.setClocks(append(clocks, r.events[:r.next]...))
+	}
+
+	// Tell viewers who is where now. Everyone is removed first so clients drop
+	// the movement they smoothed from before the jump in time.
+	for _, event := range before {
+		event := event
+		r.queue(func() { sendReplayLeave(event) })
+	}
+	for _, event := range r.players {
+		event := event
+		r.queue(func() { sendReplayState(event) })
+	}
+}
+
+// sendReplayState sends a recorded player's state to viewers in their world,
+// stamped with the recorded clock that the world clock follows during playback
+func sendReplayState(event common.RecordEvent) {
+	w := findWorld(event.World)
+	if w == nil {
+		return
+	}
+	state := event.State
+	state.Time = event.Time
+	broadcast("API.UpdatePersonState", &state, func(c *connectedPerson) bool {
+		return c.getWorld() == w && c.getState().Name != state.Name
+	})
+}
+
+// sendReplayLeave tells viewers in a world that a recorded player left it
+func sendReplayLeave(event common.RecordEvent) {
+	w := findWorld(event.World)
+	if w == nil {
+		return
+	}
+	broadcast("API.PersonDisconnected", event.Name, func(c *connectedPerson) bool {
+		return c.getWorld() == w
+	})
+}
+
+// setCell sets a recorded edit's cell to a material and queues sending it to
+// viewers who have its chunk. The caller holds the mutex.
+func (r *replay) setCell(event common.RecordEvent, material int) {
+	w := findWorld(event.World)
+	if w == nil {
+		return
+	}
+	planet := w.planet(event.Edit.Planet)
+	if planet == nil {
+		return
+	}
+	w.editMutex.Lock()
+	planet.SetCellMaterial(event.Edit.Index, material, false)
+	w.editMutex.Unlock()
+	args := event.Edit
+	args.Material = material
+	chunk := common.PlanetChunkIndex{Planet: args.Planet, ChunkIndex: planet.CellIndexToChunkIndex(args.Index)}
+	r.queue(func() {
+		broadcast("API.SetCellMaterial", &args, func(c *connectedPerson) bool {
+			return c.getWorld() == w && c.hasChunk(chunk)
+		})
+	})
+}
+
+func replayCommand(ctx *commandContext, args []string) error {
+	r := replaying
+	r.mutex.Lock()
+	defer r.flush()
+	defer r.mutex.Unlock()
+	if len(args) > 0 {
+		switch args[0] {
+		case "play":
+			if r.next >= len(r.events) {
+				r.seek(0)
+			}
+			r.paused = false
+		case "pause":
+			r.paused = true
+		case "speed":
+			if e := needArgs(args, 2, commands["replay"].usage); e != nil {
+				return e
+			}
+			speed, e := strconv.ParseFloat(args[1], 64)
+			if e != nil || speed <= 0 {
+				return errors.New("speed must be a positive number")
+			}
+			r.speed = speed
+		case "seek":
+			if e := needArgs(args, 2, commands["replay"].usage); e != nil {
+				return e
+			}
+			seconds, e := strconv.ParseFloat(args[1], 64)
+			if e != nil {
+				return errors.New("usage: " + commands["replay"].usage)
+			}
+			if strings.HasPrefix(args[1], "+") || strings.HasPrefix(args[1], "-") {
+				seconds += r.elapsed
+			}
+			r.seek(seconds)
+		default:
+			return errors.New("usage: " + commands["replay"].usage)
+		}
+	}
+	state := "playing"
+	if r.paused {
+		state = "paused"
+	}
+	ctx.replyf("Replay %v at %.1f of %.1f seconds, speed %vx, %v players", state, r.elapsed, r.length(), r.speed, len(r.players))
+	return nil
+}
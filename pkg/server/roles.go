@@ -0,0 +1,187 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Roles, from least to most trusted
+const (
+	roleVisitor = "visitor"
+	roleBuilder = "builder"
+	roleAdmin   = "admin"
+	roleOwner   = "owner"
+)
+
+var roles = []string{roleVisitor, roleBuilder, roleAdmin, roleOwner}
+
+// Permissions checked by the API handlers and commands. The console has them all.
+const (
+	permAnyone   = ""
+	permEdit     = "edit"
+	permPvP      = "pvp"
+	permFly      = "fly"
+	permSpectate = "spectate"
+	permTeleport = "teleport"
+	permGameMode = "gamemode"
+	permGive     = "give"
+	permAdmin    = "admin"
+	permRoles    = "roles"
+)
+
+// Admins run the server day to day. Only owners decide who has which role and
+// register accounts.
+var rolePermissions = map[string][]string{
+	roleVisitor: {},
+	roleBuilder: {permEdit, permPvP, permFly},
+	roleAdmin:   {permEdit, permPvP, permFly, permSpectate, permTeleport, permGameMode, permGive, permAdmin},
+	roleOwner:   {permEdit, permPvP, permFly, permSpectate, permTeleport, permGameMode, permGive, permAdmin, permRoles},
+}
+
+func createRoleTable(db *sql.DB) {
+	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS role (name TEXT PRIMARY KEY, role TEXT)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+}
+
+// rank returns how trusted a role is, or -1 for an unknown role
+func rank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// defaultRole is the role of players who have not been given one
+func defaultRole() string {
+	role := getconfig("default_role")
+	if rank(role) < 0 {
+		return roleBuilder
+	}
+	return role
+}
+
+// isOp returns whether a name is listed in the ops config
+func isOp(name string) bool {
+	for _, op := range strings.Split(getconfig("ops"), ",") {
+		if strings.TrimSpace(op) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// roleOf looks up a player's role. Players listed in the ops config are always owners.
+func roleOf(db *sql.DB, name string) string {
+	if isOp(name) {
+		return roleOwner
+	}
+	var role string
+	err := db.QueryRow("SELECT role FROM role WHERE name = ?", name).Scan(&role)
+	if err == sql.ErrNoRows || rank(role) < 0 {
+		return defaultRole()
+	}
+	checkErr(err)
+	return role
+}
+
+// setRole stores a player's role
+func setRole(db *sql.DB, name, role string) {
+	_, err := db.Exec("INSERT OR REPLACE INTO role VALUES (?, ?)", name, role)
+	checkErr(err)
+}
+
+// roleAssignments returns every stored role by player name
+func roleAssignments(db *sql.DB) map[string]string {
+	rows, err := db.Query("SELECT name, role FROM role")
+	checkErr(err)
+	defer rows.Close()
+	assignments := make(map[string]string)
+	for rows.Next() {
+		var name, role string
+		checkErr(rows.Scan(&name, &role))
+		assignments[name] = role
+	}
+	return assignments
+}
+
+// can returns whether the person's role grants a permission
+func (c *connectedPerson) can(permission string) bool {
+	if permission == permAnyone {
+		return true
+	}
+	c.mutex.Lock()
+	role := c.role
+	c.mutex.Unlock()
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// forceGameMode puts the person back in a game mode they are allowed to use
+func (c *connectedPerson) forceGameMode(mode int) {
+	c.mutex.Lock()
+	c.state.GameMode = mode
+	c.mutex.Unlock()
+	c.send("API.SetGameMode", &mode)
+}
+
+func roleCommand(ctx *commandContext, args []string) error {
+	if len(args) == 0 {
+		assignments := roleAssignments(db)
+		names := []string{}
+		for name := range assignments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		ctx.replyf("Default role is %v", defaultRole())
+		for _, name := range names {
+			if isOp(name) {
+				continue
+			}
+			ctx.replyf("%v: %v", name, assignments[name])
+		}
+		for _, op := range strings.Split(getconfig("ops"), ",") {
+			if op = strings.TrimSpace(op); op != "" {
+				ctx.replyf("%v: %v (ops config)", op, roleOwner)
+			}
+		}
+		return nil
+	}
+	name := args[0]
+	if len(args) == 1 {
+		ctx.replyf("%v is %v", name, roleOf(db, name))
+		return nil
+	}
+	role := args[1]
+	if rank(role) < 0 {
+		return fmt.Errorf("unknown role %v, use one of %v", role, strings.Join(roles, ", "))
+	}
+	// The stored role would be ignored, so say so instead of pretending to change it
+	if isOp(name) {
+		return fmt.Errorf("%v is in the ops config, which always makes them %v", name, roleOwner)
+	}
+	setRole(db, name, role)
+	if c := findPerson(name); c != nil {
+		c.mutex.Lock()
+		c.role = role
+		c.mutex.Unlock()
+		mode := c.getState().GameMode
+		if (mode == common.Flying && !c.can(permFly)) || (mode == common.Spectator && !c.can(permSpectate)) {
+			c.setGameMode(common.Normal)
+		}
+		c.tell("You are now " + role)
+	}
+	ctx.replyf("%v is now %v", name, role)
+	return nil
+}
@@ -3,41 +3,220 @@ package server
 import (
 	"errors"
 	"log"
+	"strings"
 
 	"github.com/jeffbaumes/buildorb/pkg/common"
 )
 
-// API is the RPC tag for server calls
+// API is the RPC tag for server calls, one per connected client
 type API struct {
-	connectedPeople []*connectedPerson
+	person *connectedPerson
 }
 
-// GetPlanetStates returns all planets
+var errNotLoggedIn = errors.New("Not logged in")
+
+// checkLogin returns an error if the person has not logged in yet
+func (api *API) checkLogin() error {
+	api.person.mutex.Lock()
+	defer api.person.mutex.Unlock()
+	if !api.person.loggedIn {
+		return errNotLoggedIn
+	}
+	return nil
+}
+
+// Login authenticates the person and adds them to the game in the world they
+// asked for, or the main world. An existing session with the same name is kicked.
+func (api *API) Login(args *common.LoginArgs, reply *common.LoginReply) error {
+	if api.checkLogin() == nil {
+		return errors.New("Already logged in")
+	}
+	w := findWorld(args.World)
+	if w == nil {
+		return errUnknownWorld
+	}
+	if isBanned(db, args.Name) {
+		log.Printf("Banned player %v tried to log in", args.Name)
+		return errBanned
+	}
+	// Every attempt takes a token, so logins running at once cannot all guess,
+	// and successful ones give it back
+	failures := loginFailureLimit(args.Name)
+	if !failures.allow(1) {
+		log.Printf("Login refused for %v: %v", args.Name, errTooManyFailures)
+		return errTooManyFailures
+	}
+	token, e := authenticate(db, args.Name, args.Password, args.Token)
+	if e != nil {
+		log.Printf("Login failed for %v: %v", args.Name, e)
+		return e
+	}
+	failures.give(1)
+	role := roleOf(db, args.Name)
+	if e := api.person.loadChatSettings(db, args.Name); e != nil {
+		log.Printf("Login failed for %v: %v", args.Name, e)
+		return e
+	}
+	// Swap out an older session while holding the lock so two logins with one
+	// name cannot both join. It is closed after the lock is released, since
+	// closing can wait on a slow client.
+	// Players come back where they left the world, so a client that lost its
+	// connection carries on. Clients that start again land at the home spawn,
+	// which is also allowed once.
+	start, saved := w.loadPlayer(args.Name)
+	if !saved {
+		home := w.planet(0)
+		if home == nil {
+			return errors.New("world has no home planet")
+		}
+		start = common.PlayerState{Planet: home.ID, Position: home.SpawnPoint()}
+	}
+	accountMutex.Lock()
+	old := findPerson(args.Name)
+	if old != nil {
+		removePerson(old)
+		if old.getWorld() == w {
+			start = old.getState()
+		}
+	}
+	api.person.mutex.Lock()
+	api.person.state.Name = args.Name
+	api.person.role = role
+	api.person.world = w
+	api.person.state.Planet = start.Planet
+	api.person.state.Position = start.Position
+	api.person.spawnPlanet = 0
+	if replaying != nil {
+		api.person.state.GameMode = common.Spectator
+	}
+	api.person.loggedIn = true
+	api.person.mutex.Unlock()
+	addPerson(api.person)
+	accountMutex.Unlock()
+	if old != nil {
+		log.Printf("%v logged in again, closing old session", args.Name)
+		old.disconnect()
+	}
+	go api.person.sendChatHistory()
+	record(w, common.RecordEvent{Kind: common.RecordJoin, Name: args.Name})
+	log.Printf("%v logged in to world %v", args.Name, w.name)
+	reply.Token = token
+	return nil
+}
+
+// Ping lets a client check that the server is still there
+func (api *API) Ping(args *int, ret *bool) error {
+	*ret = true
+	return nil
+}
+
+// GetTime returns the universe clock of the person's world in seconds
+func (api *API) GetTime(args *int, seconds *float64) error {
+	w := api.person.getWorld()
+	if w == nil {
+		w = mainWorld
+	}
+	*seconds = w.universe.Time()
+	return nil
+}
+
+// Leave removes the person from the game before their client disconnects
+func (api *API) Leave(args *int, ret *bool) error {
+	personDisconnected(api.person)
+	*ret = true
+	return nil
+}
+
+// GetPlanetStates returns all planets in the person's world
 func (api *API) GetPlanetStates(args *int, states *[]*common.PlanetState) error {
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
 	planets := []*common.PlanetState{}
-	for _, planet := range universe.PlanetMap {
+	for _, planet := range api.person.getWorld().universe.PlanetMap {
 		planets = append(planets, &planet.PlanetState)
 	}
 	*states = planets
 	return nil
 }
 
+// GetPlayers returns the states of the players in the person's world, for clients that do not receive updates
+func (api *API) GetPlayers(args *int, states *[]common.PlayerState) error {
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	list := []common.PlayerState{}
+	for _, c := range api.person.getWorld().players() {
+		if c != api.person && c.isSpectating() {
+			continue
+		}
+		list = append(list, c.getState())
+	}
+	*states = list
+	return nil
+}
+
 // GetChunk returns the planet chunk for the given chunk coordinates
 func (api *API) GetChunk(args *common.PlanetChunkIndex, chunk *common.Chunk) error {
-	planet := universe.PlanetMap[args.Planet]
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	planet := api.person.getWorld().planet(args.Planet)
 	if planet == nil {
 		return errors.New("Unknown planet ID")
 	}
 	c := planet.GetChunk(args.ChunkIndex, false)
 	if c != nil {
 		*chunk = *c
+		api.person.addChunk(*args)
+	}
+	return nil
+}
+
+// StartChunkStream starts pushing chunks within radius chunks of the person to
+// their client. The radius is capped by max_stream_radius.
+func (api *API) StartChunkStream(radius *int, ret *bool) error {
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	if *radius < 0 {
+		return errors.New("Invalid stream radius")
 	}
+	r := common.Min(*radius, getconfigint("max_stream_radius", defaultMaxStreamRadius))
+	api.person.mutex.Lock()
+	streaming := api.person.streaming
+	api.person.streaming = true
+	api.person.mutex.Unlock()
+	if !streaming {
+		go api.person.streamChunks(r, getconfigint("chunk_bandwidth", defaultChunkBandwidth))
+	}
+	*ret = true
+	return nil
+}
+
+// ChangePlanet sends the person to the spawn point of another planet in their world
+func (api *API) ChangePlanet(id *int, ret *bool) error {
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	if api.person.getWorld().planet(*id) == nil {
+		return errors.New("Unknown planet ID")
+	}
+	go func() {
+		if e := api.person.sendToSpawn(*id); e != nil && e != errNoCallbacks {
+			log.Printf("API.SetPlanet error: %v", e)
+		}
+	}()
+	*ret = true
 	return nil
 }
 
 // GetPlanetGeometry returns the low resolution geometry for a planet
 func (api *API) GetPlanetGeometry(planetID *int, geom *common.PlanetGeometry) error {
-	planet := universe.PlanetMap[*planetID]
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	planet := api.person.getWorld().planet(*planetID)
 	if planet == nil {
 		return errors.New("Unknown planet ID")
 	}
@@ -48,100 +227,209 @@ func (api *API) GetPlanetGeometry(planetID *int, geom *common.PlanetGeometry) er
 	return nil
 }
 
-// UpdatePersonState updates a person's position
+// UpdatePersonState updates a person's position and sends it to interested players
 func (api *API) UpdatePersonState(state *common.PlayerState, ret *bool) error {
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		if c.state.Name == state.Name {
-			c.state = *state
-		} else {
-			var r bool
-			e := c.rpc.Call("API.UpdatePersonState", state, &r)
-			if e != nil {
-				if e.Error() == "connection is shut down" {
-					api.personDisconnected(c.state.Name)
-					continue
-				}
-				log.Println("UpdatePersonState error:", e)
-			}
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	state.Name = api.person.getState().Name
+	if api.person.isSwitchingWorld() {
+		*ret = false
+		return nil
+	}
+	// Only the server moves players in and out of spectator mode
+	spectating := api.person.isSpectating()
+	if spectating != (state.GameMode == common.Spectator) {
+		mode := common.Normal
+		if spectating {
+			mode = common.Spectator
 		}
-		validPeople = append(validPeople, c)
+		api.person.forceGameMode(mode)
+		state.GameMode = mode
+	}
+	if state.GameMode == common.Flying && !api.person.can(permFly) {
+		api.person.forceGameMode(common.Normal)
+		state.GameMode = common.Normal
+	}
+	if e := api.person.validateMove(state); e != nil {
+		api.person.correctMove(e)
+		*ret = false
+		return nil
+	}
+	api.person.setState(*state)
+	if spectating {
+		*ret = true
+		return nil
 	}
-	api.connectedPeople = validPeople
+	w := api.person.getWorld()
+	record(w, common.RecordEvent{Kind: common.RecordState, Name: state.Name, State: *state})
+	broadcast("API.UpdatePersonState", state, func(c *connectedPerson) bool {
+		return c != api.person && c.getWorld() == w && c.wantsPlayerUpdate(state)
+	})
+	summary := common.PlayerSummary{Name: state.Name, Planet: state.Planet, Position: state.Position, Time: state.Time}
+	broadcast("API.UpdatePersonSummary", &summary, func(c *connectedPerson) bool {
+		return c != api.person && c.getWorld() == w && c.wantsPlayerSummary(state)
+	})
 	*ret = true
 	return nil
 }
 
-// SendText sends a text to all players
+// SendText sends a chat line from the person to their chat channel, or runs it
+// as a command if it starts with a slash
 func (api *API) SendText(text *string, ret *bool) error {
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		var r bool
-		e := c.rpc.Call("API.SendText", text, &r)
-		if e != nil {
-			if e.Error() == "connection is shut down" {
-				api.personDisconnected(c.state.Name)
-				continue
-			}
-			log.Println("UpdatePersonState error:", e)
-		}
-		validPeople = append(validPeople, c)
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	line := strings.TrimSpace(*text)
+	if line == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, "/") {
+		ctx := &commandContext{person: api.person, reply: api.person.tell}
+		runCommand(ctx, line[1:])
+		*ret = true
+		return nil
+	}
+	api.person.mutex.Lock()
+	channel := api.person.chatChannel
+	api.person.mutex.Unlock()
+	if e := personChat(api.person, channel, line); e != nil {
+		api.person.tell("Error: " + e.Error())
 	}
-	api.connectedPeople = validPeople
 	*ret = true
 	return nil
 }
 
-// HitPlayer damages a person
-func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		if c.state.Name == args.Target {
-			var r bool
-			e := c.rpc.Call("API.HitPlayer", args, &r)
-			if e != nil {
-				if e.Error() == "connection is shut down" {
-					api.personDisconnected(c.state.Name)
+// SetCellMaterials changes many cells at once. The edits are checked together
+// and either all applied or all rejected, and the changed chunks are saved in
+// one transaction.
+func (api *API) SetCellMaterials(args *common.RPCSetCellMaterialsArgs, ret *bool) error {
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	w := api.person.getWorld()
+	w.editMutex.Lock()
+	if e := api.person.validateEdits(w, args.Edits); e != nil {
+		api.person.rejectEdits(w, args.Edits, e)
+		w.editMutex.Unlock()
+		*ret = false
+		return nil
+	}
+	name := api.person.getState().Name
+	byPlanet := make(map[int][]common.RPCSetCellMaterialArgs)
+	for _, edit := range args.Edits {
+		byPlanet[edit.Planet] = append(byPlanet[edit.Planet], edit)
+	}
+	changed := []common.RPCSetCellMaterialArgs{}
+	chunks := make(map[common.PlanetChunkIndex]bool)
+	for id, edits := range byPlanet {
+		planet := w.planet(id)
+		// Work out what each change replaces, following earlier edits to the same cell
+		current := make(map[common.CellIndex]int)
+		events := []common.RecordEvent{}
+		for _, edit := range edits {
+			previous, ok := current[edit.Index]
+			if !ok {
+				cell := planet.CellIndexToCell(edit.Index)
+				if cell == nil {
 					continue
 				}
-				log.Println("HitPlayer error:", e)
+				previous = cell.Material
+			}
+			if previous == edit.Material {
+				continue
 			}
+			current[edit.Index] = edit.Material
+			events = append(events, common.RecordEvent{Kind: common.RecordEdit, Name: name, Edit: edit, Previous: previous})
 		}
-		validPeople = append(validPeople, c)
+		for _, edit := range planet.SetCellMaterials(edits, false) {
+			changed = append(changed, edit)
+			chunks[common.PlanetChunkIndex{Planet: id, ChunkIndex: planet.CellIndexToChunkIndex(edit.Index)}] = true
+		}
+		for _, event := range events {
+			record(w, event)
+		}
+	}
+	w.editMutex.Unlock()
+	*ret = len(changed) > 0
+	if len(changed) == 0 {
+		return nil
+	}
+	broadcast("API.SetCellMaterials", &common.RPCSetCellMaterialsArgs{Edits: changed}, func(c *connectedPerson) bool {
+		if c.getWorld() != w {
+			return false
+		}
+		for chunk := range chunks {
+			if c.hasChunk(chunk) {
+				return true
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// HitPlayer damages a person within reach. The server sets the damage.
+func (api *API) HitPlayer(args *common.HitPlayerArgs, ret *bool) error {
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	if !api.person.can(permPvP) || api.person.isSpectating() {
+		return errors.New("Not allowed to hit players")
+	}
+	state := api.person.getState()
+	if e := api.person.checkClaimAt(state.Planet, state.Position, actionPvP); e != nil {
+		return e
 	}
-	api.connectedPeople = validPeople
+	target := findPerson(args.Target)
+	if target == nil || target.getWorld() != api.person.getWorld() || target.isSpectating() {
+		return errors.New("No such player in this world")
+	}
+	t := target.getState()
+	if t.Planet != state.Planet || t.Position.Sub(state.Position).Len() > maxReach {
+		return errors.New("Player is out of reach")
+	}
+	if e := api.person.checkClaimAt(t.Planet, t.Position, actionPvP); e != nil {
+		return e
+	}
+	args.From = state.Name
+	args.Amount = hitDamage
+	broadcast("API.HitPlayer", args, func(c *connectedPerson) bool {
+		return c == target
+	})
 	*ret = true
 	return nil
 }
 
-// SetCellMaterial sets the material for a particular cell
+// SetCellMaterial sets the material for a particular cell and sends it to players who have its chunk
 func (api *API) SetCellMaterial(args *common.RPCSetCellMaterialArgs, ret *bool) error {
-	planet := universe.PlanetMap[args.Planet]
+	if e := api.checkLogin(); e != nil {
+		return e
+	}
+	w := api.person.getWorld()
+	planet := w.planet(args.Planet)
 	if planet == nil {
 		return errors.New("Unknown planet ID")
 	}
+	w.editMutex.Lock()
+	if e := api.person.validateEdit(planet, args); e != nil {
+		api.person.rejectEdit(planet, args, e)
+		w.editMutex.Unlock()
+		*ret = false
+		return nil
+	}
+	previous := common.Air
+	if cell := planet.CellIndexToCell(args.Index); cell != nil {
+		previous = cell.Material
+	}
 	*ret = planet.SetCellMaterial(args.Index, args.Material, false)
-	var validPeople []*connectedPerson
-	for _, c := range api.connectedPeople {
-		var ret bool
-		e := c.rpc.Call("API.SetCellMaterial", args, &ret)
-		if e != nil {
-			if e.Error() == "connection is shut down" {
-				api.personDisconnected(c.state.Name)
-				continue
-			}
-			log.Println("SetCellMaterial error:", e)
-		}
-		validPeople = append(validPeople, c)
+	if *ret {
+		record(w, common.RecordEvent{Kind: common.RecordEdit, Name: api.person.getState().Name, Edit: *args, Previous: previous})
 	}
-	api.connectedPeople = validPeople
+	w.editMutex.Unlock()
+	chunk := common.PlanetChunkIndex{Planet: args.Planet, ChunkIndex: planet.CellIndexToChunkIndex(args.Index)}
+	broadcast("API.SetCellMaterial", args, func(c *connectedPerson) bool {
+		return c.getWorld() == w && c.hasChunk(chunk)
+	})
 	return nil
 }
-
-func (api *API) personDisconnected(name string) {
-	log.Printf("%v disconnected", name)
-	for _, c := range api.connectedPeople {
-		var ret bool
-		c.rpc.Call("API.PersonDisconnected", name, &ret)
-	}
-}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// spectateReturn is where a person goes back to when they stop spectating
+type spectateReturn struct {
+	world    *world
+	planet   int
+	position mgl32.Vec3
+}
+
+func (c *connectedPerson) isSpectating() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state.GameMode == common.Spectator
+}
+
+// setGameMode changes the person's game mode and tells their client. Spectators
+// are hidden from everyone else, and go back to where they started spectating
+// when they switch to another mode.
+func (c *connectedPerson) setGameMode(mode int) error {
+	c.mutex.Lock()
+	was := c.state.GameMode
+	if mode == common.Spectator && was != common.Spectator {
+		c.spectateReturn = &spectateReturn{c.world, c.state.Planet, c.state.Position}
+	}
+	back := c.spectateReturn
+	if mode != common.Spectator {
+		c.spectateReturn = nil
+	}
+	c.state.GameMode = mode
+	name := c.state.Name
+	w := c.world
+	c.mutex.Unlock()
+
+	var ret bool
+	if e := c.call("API.SetGameMode", &mode, &ret); e != nil {
+		return e
+	}
+	if mode == common.Spectator && was != common.Spectator {
+		record(w, common.RecordEvent{Kind: common.RecordLeave, Name: name})
+		broadcast("API.PersonDisconnected", name, func(o *connectedPerson) bool {
+			return o != c && o.getWorld() == w
+		})
+	}
+	if mode != common.Spectator && was == common.Spectator && back != nil {
+		if e := c.moveToWorld(back.world); e != nil {
+			return e
+		}
+		return c.teleport(back.planet, back.position)
+	}
+	return nil
+}
+
+// follow attaches a spectator's view to another player, moving to their world if needed
+func (c *connectedPerson) follow(target *connectedPerson) error {
+	if target == c {
+		return errors.New("cannot follow yourself")
+	}
+	if target.isSpectating() {
+		return errors.New("cannot follow a spectator")
+	}
+	if !c.isSpectating() {
+		if e := c.setGameMode(common.Spectator); e != nil {
+			return e
+		}
+	}
+	if e := c.moveToWorld(target.getWorld()); e != nil {
+		return e
+	}
+	state := target.getState()
+	if e := c.teleport(state.Planet, state.Position); e != nil {
+		return e
+	}
+	var ret bool
+	return c.call("API.Follow", &state.Name, &ret)
+}
+
+func spectateCommand(ctx *commandContext, args []string) error {
+	if ctx.person == nil {
+		return errNotPlayer
+	}
+	if len(args) == 0 {
+		if e := ctx.person.setGameMode(common.Spectator); e != nil {
+			return e
+		}
+		var ret bool
+		empty := ""
+		if e := ctx.person.call("API.Follow", &empty, &ret); e != nil {
+			return e
+		}
+		ctx.reply("Spectating, use gamemode normal to stop")
+		return nil
+	}
+	target, e := needPerson(args[0])
+	if e != nil {
+		return e
+	}
+	if e := ctx.person.follow(target); e != nil {
+		return e
+	}
+	ctx.replyf("Following %v, use gamemode normal to stop", args[0])
+	return nil
+}
@@ -1,23 +1,21 @@
 package server
 
 import (
-	"database/sql"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/rpc"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/yamux"
-	"github.com/jeffbaumes/buildorb/pkg/common"
 	_ "github.com/mattn/go-sqlite3" // Needed to use sqlite
 )
 
-var (
-	universe *common.Universe
-)
+// clockSaveInterval is how often the universe clock is written to the database
+const clockSaveInterval = 10 * time.Second
 
 type server struct {
 	system string
@@ -46,90 +44,141 @@ func readfile() string {
 	return string(b)
 }
 
-func getsystem() (f5 string) {
+func getconfig(key string) (f5 string) {
 	f2 := readfile()
 	f := strings.Split(f2, ";")
 	for _, f3 := range f {
 		f4 := strings.Split(f3, "=")
-		if f4[0] == "system" {
-			f5 = f4[1]
+		if strings.TrimSpace(f4[0]) == key && len(f4) > 1 {
+			f5 = strings.TrimSpace(f4[1])
 		}
 	}
 	return
 }
 
-// Start takes a name, seed, and port and starts the universe server
+func getconfigint(key string, def int) int {
+	v, err := strconv.Atoi(getconfig(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getsystem() string {
+	return getconfig("system")
+}
+
+// Start takes a name, seed, and port and starts the universe server. Other
+// worlds listed in the worlds config are hosted alongside the named one.
 func Start(name string, seed, port int) {
 	if port == 0 {
 		port = 5555
 	}
-	dbName := "worlds/" + name + ".db"
-
-	db, err := sql.Open("sqlite3", dbName)
-	checkErr(err)
-
-	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS chunk (planet INT, lon INT, lat INT, alt INT, data BLOB, PRIMARY KEY (planet, lat, lon, alt))")
-	checkErr(err)
-	_, err = stmt.Exec()
-	checkErr(err)
-	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS planet (id INT PRIMARY KEY, data BLOB)")
-	checkErr(err)
-	_, err = stmt.Exec()
-	checkErr(err)
-	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS player (name TEXT PRIMARY KEY, data BLOB)")
-	checkErr(err)
-	_, err = stmt.Exec()
-	checkErr(err)
+	serverPort = port
+	spawnProtection = getconfigint("spawn_protection", defaultSpawnProtection)
+	openWorlds(name)
+	log.Printf("Hosting %v world(s)", len(allWorlds()))
 
-	universe = common.NewUniverse(db, getsystem())
-
-	api := new(API)
-	listener, e := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	listener, e := listen(port)
 	if e != nil {
 		log.Fatal("listen error:", e)
 	}
-	log.Printf("Server listening on port %v...\n", port)
-	for {
-		conn, e := listener.Accept()
-		if e != nil {
-			panic(e)
+	if serverTLS != nil {
+		log.Printf("Server listening with TLS on port %v...\n", port)
+	} else {
+		log.Printf("Server listening on port %v...\n", port)
+	}
+	if wsPort := getconfigint("websocket_port", 0); wsPort != 0 {
+		go serveWebSocket(wsPort)
+	}
+	go announce(name, port)
+	if metricsPort := getconfigint("metrics_port", 0); metricsPort != 0 {
+		metricsEnabled = true
+		go serveMetrics(metricsPort)
+	}
+	if adminPort := getconfigint("admin_port", 0); adminPort != 0 {
+		go serveAdminAPI(adminPort)
+	}
+	if consoleEnabled {
+		go runConsole()
+	}
+	if getconfigint("record", 0) != 0 {
+		if e := startRecording(""); e != nil {
+			log.Println("Recording error:", e)
 		}
+	}
+	serve(listener)
+}
 
-		// Set up server side of yamux
-		mux, e := yamux.Server(conn, nil)
-		if e != nil {
-			panic(e)
-		}
-		muxConn, e := mux.Accept()
-		if e != nil {
-			panic(e)
+// openWorlds opens the main world, which also holds the server tables, and the
+// other worlds in the worlds config
+func openWorlds(name string) {
+	mainWorld = openWorld(name)
+	db = mainWorld.db
+	createAccountTable(db)
+	createBanTable(db)
+	createRoleTable(db)
+	createChatTables(db)
+	for _, other := range extraWorlds() {
+		if findWorld(other) == nil {
+			openWorld(other)
 		}
-		srpc := rpc.NewServer()
-		srpc.Register(api)
-		go srpc.ServeConn(muxConn)
+	}
+}
 
-		// Set up stream back to client
-		stream, e := mux.Open()
+// serve accepts client connections until the listener fails
+func serve(listener net.Listener) {
+	for {
+		conn, e := listener.Accept()
 		if e != nil {
 			panic(e)
 		}
-		crpc := rpc.NewClient(stream)
-
-		// Ask client for player name
-		var state common.PlayerState
-		e = crpc.Call("API.GetPersonState", 0, &state)
-		if e != nil {
-			log.Fatal("GetPersonState error:", e)
-		}
-		p := connectedPerson{state: state, rpc: crpc}
-		log.Println(p.state.Name)
-		api.connectedPeople = append(api.connectedPeople, &p)
+		go serveConn(conn)
 	}
 }
 
-type connectedPerson struct {
-	rpc   *rpc.Client
-	state common.PlayerState
+// serveConn sets up the RPC streams for a new client connection. The person
+// joins the game once their client logs in.
+func serveConn(conn net.Conn) {
+	traffic := &countingConn{Conn: conn}
+
+	// Set up server side of yamux
+	config := yamux.DefaultConfig()
+	config.KeepAliveInterval = heartbeatInterval()
+	config.ConnectionWriteTimeout = heartbeatTimeout()
+	mux, e := yamux.Server(traffic, config)
+	if e != nil {
+		log.Println("yamux error:", e)
+		conn.Close()
+		return
+	}
+	muxConn, e := mux.Accept()
+	if e != nil {
+		log.Println("yamux accept error:", e)
+		mux.Close()
+		return
+	}
+
+	// Set up stream back to client
+	stream, e := mux.Open()
+	if e != nil {
+		log.Println("yamux open error:", e)
+		mux.Close()
+		return
+	}
+	p := newConnectedPerson()
+	p.session = mux
+	p.traffic = traffic
+	p.rpc = rpc.NewClient(stream)
+
+	srpc := rpc.NewServer()
+	srpc.Register(&API{person: p})
+	go p.heartbeat()
+	go p.sendLoop()
+
+	// Serve until the client leaves or the connection drops, then clean up
+	serveCodec(srpc, newGobServerCodec(muxConn))
+	p.disconnect()
 }
 
 func checkErr(err error) {
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Chunk streaming limits, overridable with the chunk_bandwidth and
+// max_stream_radius keys in server.buildorb
+const (
+	// defaultChunkBandwidth is the chunk streaming cap in bytes per second per client
+	defaultChunkBandwidth = 512 * 1024
+	// defaultMaxStreamRadius is the largest radius in chunks a client may stream
+	defaultMaxStreamRadius = 8
+	// streamQueueLimit is how many calls may be waiting in a person's send
+	// queue before their stream waits for it to drain
+	streamQueueLimit = 16
+)
+
+// nearbyChunks returns the chunks within radius chunks of a position, nearest first
+func nearbyChunks(planet *common.Planet, pos mgl32.Vec3, radius int) []common.ChunkIndex {
+	lonChunks := planet.LonCells / common.ChunkSize
+	ind := planet.CartesianToChunkIndex(pos)
+	seen := make(map[common.ChunkIndex]bool)
+	chunks := []common.ChunkIndex{}
+	for lon := ind.Lon - radius; lon <= ind.Lon+radius; lon++ {
+		validLon := ((lon % lonChunks) + lonChunks) % lonChunks
+		latMin := common.Max(ind.Lat-radius, 0)
+		latMax := common.Min(ind.Lat+radius, planet.LatCells/common.ChunkSize-1)
+		for lat := latMin; lat <= latMax; lat++ {
+			for alt := 0; alt < planet.AltCells/common.ChunkSize; alt++ {
+				c := common.ChunkIndex{Lon: validLon, Lat: lat, Alt: alt}
+				if !seen[c] {
+					seen[c] = true
+					chunks = append(chunks, c)
+				}
+			}
+		}
+	}
+	dist := func(c common.ChunkIndex) float32 {
+		center := common.CellIndex{
+			Lon: c.Lon*common.ChunkSize + common.ChunkSize/2,
+			Lat: c.Lat*common.ChunkSize + common.ChunkSize/2,
+			Alt: c.Alt*common.ChunkSize + common.ChunkSize/2,
+		}
+		return planet.CellIndexToCartesian(center).Sub(pos).Len()
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return dist(chunks[i]) < dist(chunks[j])
+	})
+	return chunks
+}
+
+// streamChunks pushes compressed chunks around the person's position to their client.
+// The queue is rebuilt whenever the person moves to a new chunk or world, which drops
+// chunks that fell out of range. Those are also forgotten as sent, so they are sent
+// again if the person comes back. Chunks go through the send queue, encoded and
+// marked as sent under the world's edit lock, so an edit is either in the chunk or
+// sent after it.
+func (c *connectedPerson) streamChunks(radius int, bytesPerSecond int) {
+	bucket := newTokenBucket(float64(bytesPerSecond), float64(bytesPerSecond))
+	var queue []common.PlanetChunkIndex
+	var center common.PlanetChunkIndex
+	var centerWorld *world
+	first := true
+	if c.rpc == nil {
+		return
+	}
+	for {
+		state := c.getState()
+		w := c.getWorld()
+		planet := w.planet(state.Planet)
+		if planet == nil || c.isSwitchingWorld() {
+			if !c.sleep(100 * time.Millisecond) {
+				return
+			}
+			continue
+		}
+		cur := common.PlanetChunkIndex{Planet: planet.ID, ChunkIndex: planet.CartesianToChunkIndex(state.Position)}
+		if first || cur != center || w != centerWorld {
+			first = false
+			center = cur
+			centerWorld = w
+			queue = queue[:0]
+			inRange := make(map[common.PlanetChunkIndex]bool)
+			for _, ind := range nearbyChunks(planet, state.Position, radius) {
+				pind := common.PlanetChunkIndex{Planet: planet.ID, ChunkIndex: ind}
+				inRange[pind] = true
+				if !c.hasChunk(pind) {
+					queue = append(queue, pind)
+				}
+			}
+			c.pruneChunks(inRange)
+		}
+		if len(queue) == 0 {
+			if !c.sleep(100 * time.Millisecond) {
+				return
+			}
+			continue
+		}
+		pind := queue[0]
+		queue = queue[1:]
+		if c.hasChunk(pind) {
+			continue
+		}
+		chunk := planet.GetChunk(pind.ChunkIndex, false)
+		if chunk == nil {
+			continue
+		}
+		for len(c.sendQueue) > streamQueueLimit {
+			if !c.sleep(10 * time.Millisecond) {
+				return
+			}
+		}
+		w.editMutex.Lock()
+		if c.getWorld() != w {
+			w.editMutex.Unlock()
+			continue
+		}
+		c.addChunk(pind)
+		data, e := common.EncodeChunk(chunk)
+		if e != nil {
+			w.editMutex.Unlock()
+			log.Println("EncodeChunk error:", e)
+			continue
+		}
+		c.send("API.ReceiveChunk", &common.ChunkData{Planet: pind.Planet, ChunkIndex: pind.ChunkIndex, Data: data})
+		w.editMutex.Unlock()
+		atomic.AddInt64(&chunksStreamed, 1)
+		bucket.wait(float64(len(data)))
+	}
+}
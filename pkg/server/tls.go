@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Default files for the certificate and key made by tls=auto
+const (
+	defaultCertFile = "server.crt"
+	defaultKeyFile  = "server.key"
+)
+
+// serverTLS is the TLS configuration for client connections, or nil when TLS is off
+var serverTLS *tls.Config
+
+// loadTLS reads the TLS settings from server.buildorb. TLS is on when tls_cert
+// and tls_key name PEM files, or when tls=auto, which makes a self-signed pair
+// in those files (server.crt and server.key by default) unless both exist.
+func loadTLS() *tls.Config {
+	certFile := getconfig("tls_cert")
+	keyFile := getconfig("tls_key")
+	auto := getconfig("tls") == "auto"
+	if !auto && (certFile == "" || keyFile == "") {
+		return nil
+	}
+	if certFile == "" {
+		certFile = defaultCertFile
+	}
+	if keyFile == "" {
+		keyFile = defaultKeyFile
+	}
+	if auto && (!fileExists(certFile) || !fileExists(keyFile)) {
+		checkErr(generateCertificate(certFile, keyFile))
+		log.Printf("Generated self-signed certificate %v and key %v", certFile, keyFile)
+	}
+	cert, e := tls.LoadX509KeyPair(certFile, keyFile)
+	checkErr(e)
+	log.Printf("TLS certificate fingerprint %v", common.Fingerprint(cert.Certificate[0]))
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+// fileExists returns whether a file is there
+func fileExists(name string) bool {
+	_, e := os.Stat(name)
+	return e == nil
+}
+
+// generateCertificate writes a new self-signed certificate and private key
+func generateCertificate(certFile, keyFile string) error {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		return e
+	}
+	serial, e := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if e != nil {
+		return e
+	}
+	name, _ := os.Hostname()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name, "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, e := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if e != nil {
+		return e
+	}
+	keyDer, e := x509.MarshalECPrivateKey(key)
+	if e != nil {
+		return e
+	}
+	if e := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); e != nil {
+		return e
+	}
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+// listen opens the listener for client connections, with TLS if it is configured
+func listen(port int) (net.Listener, error) {
+	serverTLS = loadTLS()
+	listener, e := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if e != nil || serverTLS == nil {
+		return listener, e
+	}
+	return tls.NewListener(listener, serverTLS), nil
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// Block edit limits
+const (
+	// maxReach is how far from a player's last known position they may edit cells.
+	// Clients reach 5 units, the rest allows for movement since the last update.
+	maxReach         = 8
+	defaultEditRate  = 10
+	defaultEditBurst = 20
+	// defaultMaxEditBatch is the most cells one batched edit may change,
+	// overridable with the max_edit_batch key in server.buildorb
+	defaultMaxEditBatch = 1024
+	// batchCellCost is how many edits each cell of a batch costs. Batches are
+	// cheaper than single edits but still limited by how much they change.
+	batchCellCost = 0.1
+)
+
+// hitDamage is the health a hit takes, whatever the client asks for. Hits must
+// be within maxReach.
+const hitDamage = 1
+
+// Movement limits, with some slack for network jitter
+const (
+	// playerHeight matches the height given to players in common.NewPlayer
+	playerHeight = 2
+	// maxWalkSpeed covers walking and flying in all directions
+	maxWalkSpeed = 10
+	// maxRiseSpeed is the upward speed of a jump
+	maxRiseSpeed = 8
+	// maxFallSpeed is well past the speed reached falling through a whole planet
+	maxFallSpeed = 100
+	// moveSlack is extra distance allowed on every update
+	moveSlack = 1
+	// minGravity is the least a player in Normal mode must speed up while
+	// falling. Clients fall at 20, but cap each frame's time, so slow ones fall slower.
+	minGravity = 10
+	// fallSlack is extra upward speed allowed to a falling player
+	fallSlack = 2
+	// spawnRadius is how far from the spawn column a player may appear after switching planets
+	spawnRadius = 2
+	// maxMoveInterval caps the time an update's movement is measured over, so
+	// a client that goes quiet cannot save up a long move
+	maxMoveInterval = 1.0
+	// correctionInterval limits how often a client is sent its corrected position
+	correctionInterval = 500 * time.Millisecond
+)
+
+// validateEdit checks that a person may set a cell to a material
+func (c *connectedPerson) validateEdit(planet *common.Planet, args *common.RPCSetCellMaterialArgs) error {
+	if e := c.checkEdit(planet, args); e != nil {
+		return e
+	}
+	if !c.editLimit.allow(1) {
+		return errors.New("Editing too fast")
+	}
+	return nil
+}
+
+// validateEdits checks a batch of edits together. A batch costs batchCellCost
+// edits for each cell, and one at least. A batch bigger than the burst is let
+// through with a full allowance and the edits after it wait for the debt.
+func (c *connectedPerson) validateEdits(w *world, edits []common.RPCSetCellMaterialArgs) error {
+	if len(edits) == 0 {
+		return errors.New("No edits")
+	}
+	if len(edits) > getconfigint("max_edit_batch", defaultMaxEditBatch) {
+		return errors.New("Too many edits in one batch")
+	}
+	for i := range edits {
+		planet := w.planet(edits[i].Planet)
+		if planet == nil {
+			return errors.New("Unknown planet ID")
+		}
+		if e := c.checkEdit(planet, &edits[i]); e != nil {
+			return e
+		}
+	}
+	if !c.editLimit.charge(math.Max(1, float64(len(edits))*batchCellCost)) {
+		return errors.New("Editing too fast")
+	}
+	return nil
+}
+
+// checkEdit checks everything about an edit except the edit rate
+func (c *connectedPerson) checkEdit(planet *common.Planet, args *common.RPCSetCellMaterialArgs) error {
+	state := c.getState()
+	if replaying != nil {
+		return errors.New("Replays cannot be edited")
+	}
+	if !c.can(permEdit) {
+		return errors.New("Not allowed to edit")
+	}
+	if args.Material < 0 || args.Material >= len(common.Materials) {
+		return errors.New("Invalid material")
+	}
+	if state.GameMode < 0 || state.GameMode >= common.NumGameModes || state.GameMode == common.Spectator {
+		return errors.New("Game mode cannot edit")
+	}
+	if state.Planet != planet.ID {
+		return errors.New("Cell is on another planet")
+	}
+	if planet.CellIndexToCartesian(args.Index).Sub(state.Position).Len() > maxReach {
+		return errors.New("Cell is out of reach")
+	}
+	action := actionBuild
+	if args.Material == common.Air {
+		action = actionBreak
+	}
+	return c.checkClaim(planet, args.Index, action)
+}
+
+// rejectEdit logs a rejected edit and sends the real cell material back so the client can undo it
+func (c *connectedPerson) rejectEdit(planet *common.Planet, args *common.RPCSetCellMaterialArgs, reason error) {
+	log.Printf("Rejected edit by %v at %v: %v", c.getState().Name, args.Index, reason)
+	cell := planet.CellIndexToCell(args.Index)
+	if cell == nil {
+		return
+	}
+	actual := &common.RPCSetCellMaterialArgs{
+		Planet:   planet.ID,
+		Index:    args.Index,
+		Material: cell.Material,
+	}
+	c.send("API.SetCellMaterial", actual)
+}
+
+// rejectEdits logs a rejected batch and sends the real cell materials back in one batch
+func (c *connectedPerson) rejectEdits(w *world, edits []common.RPCSetCellMaterialArgs, reason error) {
+	log.Printf("Rejected %v edits by %v: %v", len(edits), c.getState().Name, reason)
+	actual := []common.RPCSetCellMaterialArgs{}
+	for _, edit := range edits {
+		planet := w.planet(edit.Planet)
+		if planet == nil {
+			continue
+		}
+		if cell := planet.CellIndexToCell(edit.Index); cell != nil {
+			actual = append(actual, common.RPCSetCellMaterialArgs{Planet: edit.Planet, Index: edit.Index, Material: cell.Material})
+		}
+	}
+	if len(actual) == 0 {
+		return
+	}
+	c.send("API.SetCellMaterials", &common.RPCSetCellMaterialsArgs{Edits: actual})
+}
+
+func solidAt(planet *common.Planet, pos mgl32.Vec3) bool {
+	cell := planet.CartesianToCell(pos)
+	return cell != nil && cell.Material != common.Air
+}
+
+// validateMove checks a person's new state against their last accepted one
+func (c *connectedPerson) validateMove(state *common.PlayerState) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	prev := c.state
+	dt := now.Sub(c.lastMove).Seconds()
+	c.lastMove = now
+	planet := c.world.planet(state.Planet)
+	if planet == nil {
+		return errors.New("unknown planet")
+	}
+	pos := state.Position
+	up := pos.Normalize()
+
+	// Spectators cannot touch anything, so they may go anywhere
+	if state.GameMode == common.Spectator {
+		c.airborneSince = time.Time{}
+		return nil
+	}
+
+	// After the server sends the player to a spawn point, their client lands
+	// them somewhere in the planet's spawn column
+	if c.spawnPlanet == state.Planet && pos[0] > 0 && (mgl32.Vec2{pos[1], pos[2]}).Len() <= spawnRadius {
+		c.spawnPlanet = -1
+		c.airborneSince = time.Time{}
+		return nil
+	}
+	if state.Planet != prev.Planet {
+		return errors.New("changed planet without being sent there")
+	}
+
+	if dt < 0.05 {
+		dt = 0.05
+	}
+	if dt > maxMoveInterval {
+		dt = maxMoveInterval
+	}
+	move := pos.Sub(prev.Position)
+	rise := move.Dot(up)
+	across := move.Sub(up.Mul(rise)).Len()
+	if across > maxWalkSpeed*float32(dt)+moveSlack {
+		return fmt.Errorf("moved %.1f across in %.2fs", across, dt)
+	}
+	if rise > maxWalkSpeed*float32(dt)+moveSlack || -rise > maxFallSpeed*float32(dt)+moveSlack {
+		return fmt.Errorf("moved %.1f up in %.2fs", rise, dt)
+	}
+	if state.GameMode != common.Normal {
+		c.airborneSince = time.Time{}
+		return nil
+	}
+
+	// In Normal mode players fall unless standing on something, and cannot move through cells
+	if rise > maxRiseSpeed*float32(dt)+moveSlack {
+		return fmt.Errorf("rose %.1f in %.2fs", rise, dt)
+	}
+	feet := pos.Sub(up.Mul(playerHeight))
+	if solidAt(planet, feet.Add(up.Mul(0.5))) || solidAt(planet, pos) {
+		return errors.New("inside a solid cell")
+	}
+	if solidAt(planet, feet.Sub(up.Mul(0.5))) {
+		c.airborneSince = time.Time{}
+	} else if c.airborneSince.IsZero() {
+		c.airborneSince = now
+	} else {
+		// Off the ground, the upward speed is at most a jump's, less what
+		// gravity has taken since the middle of this update
+		air := math.Max(now.Sub(c.airborneSince).Seconds()-dt/2, 0)
+		if speed := float64(rise) / dt; speed > maxRiseSpeed-minGravity*air+fallSlack {
+			return fmt.Errorf("falling at %.1f after %.1fs in the air", -speed, air)
+		}
+	}
+	return nil
+}
+
+// correctMove logs a rejected move and sends the last accepted position back to the client
+func (c *connectedPerson) correctMove(reason error) {
+	c.mutex.Lock()
+	name := c.state.Name
+	pos := c.state.Position
+	send := time.Since(c.lastCorrection) > correctionInterval
+	if send {
+		c.lastCorrection = time.Now()
+	}
+	c.mutex.Unlock()
+	log.Printf("Suspicious movement by %v: %v", name, reason)
+	if send {
+		c.send("API.CorrectPosition", &pos)
+	}
+}
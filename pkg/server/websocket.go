@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+
+// checkOrigin decides which web pages may open a WebSocket. Pages on other
+// sites must be listed in the comma separated websocket_origins config, as in
+// https://example.com, or it can be * to allow any page. Clients that are not
+// browsers send no origin and are always allowed.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(getconfig("websocket_origins"), ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, e := url.Parse(origin)
+	if e != nil {
+		return false
+	}
+	// Pages served by this server are allowed, as in the default check
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// wsConn adapts a WebSocket to a net.Conn, sending each Write as one message.
+// With a timeout, reads fail once nothing has arrived from the client for that
+// long, and every message or pong pushes the deadline back.
+type wsConn struct {
+	ws          *websocket.Conn
+	messageType int
+	reader      io.Reader
+	timeout     time.Duration
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, r, e := c.ws.NextReader()
+			if e != nil {
+				return 0, e
+			}
+			c.reader = r
+			if e := c.extendDeadline(); e != nil {
+				return 0, e
+			}
+		}
+		n, e := c.reader.Read(b)
+		if e == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			e = nil
+		}
+		return n, e
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	e := c.ws.WriteMessage(c.messageType, b)
+	if e != nil {
+		return 0, e
+	}
+	return len(b), nil
+}
+
+// extendDeadline gives the client another timeout to send something
+func (c *wsConn) extendDeadline() error {
+	if c.timeout == 0 {
+		return nil
+	}
+	return c.ws.SetReadDeadline(time.Now().Add(c.timeout))
+}
+
+// keepAlive pings the client every interval until done is closed. Browsers
+// answer pings by themselves, which keeps the read deadline moving.
+func (c *wsConn) keepAlive(interval time.Duration, done chan struct{}) {
+	c.ws.SetPongHandler(func(string) error { return c.extendDeadline() })
+	c.extendDeadline()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if e := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.timeout)); e != nil {
+			return
+		}
+	}
+}
+
+func (c *wsConn) Close() error                       { return c.ws.Close() }
+func (c *wsConn) LocalAddr() net.Addr                { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr               { return c.ws.RemoteAddr() }
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+func (c *wsConn) SetDeadline(t time.Time) error {
+	e := c.ws.SetReadDeadline(t)
+	if e != nil {
+		return e
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+// serveWebSocket listens for WebSocket clients. Binary connections at /ws carry
+// exactly the TCP protocol. JSON connections at /ws/json speak JSON-RPC with the
+// same API, but only as requests from the client since nothing is pushed to them.
+func serveWebSocket(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, e := upgrader.Upgrade(w, r, nil)
+		if e != nil {
+			log.Println("WebSocket upgrade error:", e)
+			return
+		}
+		serveConn(&wsConn{ws: ws, messageType: websocket.BinaryMessage})
+	})
+	mux.HandleFunc("/ws/json", func(w http.ResponseWriter, r *http.Request) {
+		ws, e := upgrader.Upgrade(w, r, nil)
+		if e != nil {
+			log.Println("WebSocket upgrade error:", e)
+			return
+		}
+		serveJSONConn(&wsConn{ws: ws, messageType: websocket.TextMessage, timeout: heartbeatTimeout()})
+	})
+	log.Printf("WebSocket server listening on port %v...\n", port)
+	if serverTLS != nil {
+		// Browsers need wss:// and to be told to trust a self-signed certificate
+		s := &http.Server{Addr: fmt.Sprintf(":%v", port), Handler: mux, TLSConfig: serverTLS}
+		log.Fatal("WebSocket listen error:", s.ListenAndServeTLS("", ""))
+	}
+	log.Fatal("WebSocket listen error:", http.ListenAndServe(fmt.Sprintf(":%v", port), mux))
+}
+
+// serveJSONConn serves the API as JSON-RPC to a client that cannot receive calls.
+// Without calls there is no heartbeat, so a client that stops answering pings
+// hits the read deadline and is disconnected.
+func serveJSONConn(conn *wsConn) {
+	traffic := &countingConn{Conn: conn}
+	p := newConnectedPerson()
+	go conn.keepAlive(heartbeatInterval(), p.done)
+	p.session = traffic
+	p.traffic = traffic
+	srpc := rpc.NewServer()
+	srpc.Register(&API{person: p})
+	serveCodec(srpc, jsonrpc.NewServerCodec(traffic))
+	p.disconnect()
+}
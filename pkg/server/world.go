@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffbaumes/buildorb/pkg/common"
+)
+
+// world is a universe hosted by the server, with its own database and claims
+type world struct {
+	name        string
+	db          *sql.DB
+	universe    *common.Universe
+	claims      []*claim
+	claimsMutex *sync.Mutex
+	// editMutex is held from checking cell edits to saving them, so edits
+	// checked against the same cells cannot both be applied
+	editMutex *sync.Mutex
+}
+
+var (
+	worlds      = map[string]*world{}
+	worldsMutex = &sync.Mutex{}
+	// mainWorld is the world the server was started with. Players join it unless
+	// they ask for another.
+	mainWorld *world
+	// db is the main world's database, which also holds the accounts, bans and
+	// roles shared by every world
+	db *sql.DB
+)
+
+var errUnknownWorld = errors.New("Unknown world")
+
+// openWorld opens or creates worlds/<name>.db and loads its universe
+func openWorld(name string) *world {
+	return openWorldFile(name, "worlds/"+name+".db")
+}
+
+// openWorldFile opens or creates a world's database file and loads its universe
+func openWorldFile(name, file string) *world {
+	wdb, err := sql.Open("sqlite3", file)
+	checkErr(err)
+
+	stmt, err := wdb.Prepare("CREATE TABLE IF NOT EXISTS chunk (planet INT, lon INT, lat INT, alt INT, data BLOB, PRIMARY KEY (planet, lat, lon, alt))")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = wdb.Prepare("CREATE TABLE IF NOT EXISTS planet (id INT PRIMARY KEY, data BLOB)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = wdb.Prepare("CREATE TABLE IF NOT EXISTS player (name TEXT PRIMARY KEY, data BLOB)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	stmt, err = wdb.Prepare("CREATE TABLE IF NOT EXISTS clock (id INT PRIMARY KEY, seconds REAL)")
+	checkErr(err)
+	_, err = stmt.Exec()
+	checkErr(err)
+	createClaimTable(wdb)
+
+	w := &world{name: name, db: wdb, claimsMutex: &sync.Mutex{}, editMutex: &sync.Mutex{}}
+	w.loadClaims()
+	w.universe = common.NewUniverse(wdb, getsystem())
+	go w.saveClock()
+
+	worldsMutex.Lock()
+	worlds[name] = w
+	worldsMutex.Unlock()
+	return w
+}
+
+// extraWorlds returns the names of the other worlds to host from the worlds config, separated by commas
+func extraWorlds() []string {
+	names := []string{}
+	for _, name := range strings.Split(getconfig("worlds"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// findWorld returns the hosted world with a name, or the main world if name is empty
+func findWorld(name string) *world {
+	if name == "" {
+		return mainWorld
+	}
+	worldsMutex.Lock()
+	defer worldsMutex.Unlock()
+	return worlds[name]
+}
+
+// allWorlds returns the hosted worlds sorted by name
+func allWorlds() []*world {
+	worldsMutex.Lock()
+	list := []*world{}
+	for _, w := range worlds {
+		list = append(list, w)
+	}
+	worldsMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// saveClock periodically stores the world's universe clock
+func (w *world) saveClock() {
+	for {
+		time.Sleep(clockSaveInterval)
+		if e := w.universe.SaveTime(); e != nil {
+			log.Printf("Clock save error in %v: %v", w.name, e)
+		}
+	}
+}
+
+// planet returns a planet of the world by ID, or nil
+func (w *world) planet(id int) *common.Planet {
+	return w.universe.PlanetMap[id]
+}
+
+// findPlanet finds a planet of the world by ID or name
+func (w *world) findPlanet(s string) *common.Planet {
+	if id, e := strconv.Atoi(s); e == nil {
+		return w.planet(id)
+	}
+	for _, planet := range w.universe.PlanetMap {
+		if strings.EqualFold(planet.Name, s) {
+			return planet
+		}
+	}
+	return nil
+}
+
+// savePlayer stores where a person was in the world, so they can come back there
+func (w *world) savePlayer(state common.PlayerState) {
+	var buf bytes.Buffer
+	e := gob.NewEncoder(&buf).Encode(state)
+	if e == nil {
+		_, e = w.db.Exec("INSERT OR REPLACE INTO player VALUES (?, ?)", state.Name, buf.Bytes())
+	}
+	if e != nil {
+		log.Printf("Could not save %v in world %v: %v", state.Name, w.name, e)
+	}
+}
+
+// loadPlayer returns the state a person was saved with in the world, if any
+func (w *world) loadPlayer(name string) (common.PlayerState, bool) {
+	var state common.PlayerState
+	var data []byte
+	e := w.db.QueryRow("SELECT data FROM player WHERE name = ?", name).Scan(&data)
+	if e == nil {
+		e = gob.NewDecoder(bytes.NewReader(data)).Decode(&state)
+	}
+	if e != nil {
+		if e != sql.ErrNoRows {
+			log.Printf("Could not load %v in world %v: %v", name, w.name, e)
+		}
+		return state, false
+	}
+	return state, w.planet(state.Planet) != nil
+}
+
+// players returns the connected people in the world
+func (w *world) players() []*connectedPerson {
+	list := []*connectedPerson{}
+	for _, c := range people() {
+		if c.getWorld() == w {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// moveToWorld takes the person to the spawn point of another world's home planet.
+// Chunks and movement are held back until their client has switched worlds.
+func (c *connectedPerson) moveToWorld(w *world) error {
+	old := c.getWorld()
+	if old == w {
+		return nil
+	}
+	home := w.planet(0)
+	if home == nil {
+		return errors.New("world has no home planet")
+	}
+	planets := []*common.PlanetState{}
+	for _, planet := range w.universe.PlanetMap {
+		planets = append(planets, &planet.PlanetState)
+	}
+	c.mutex.Lock()
+	state := c.state
+	c.world = w
+	c.switchingWorld = true
+	c.chunks = make(map[common.PlanetChunkIndex]bool)
+	c.lastFarUpdate = make(map[string]time.Time)
+	c.lastMove = time.Time{}
+	c.state.Planet = home.ID
+	c.state.Position = home.SpawnPoint()
+	c.spawnPlanet = home.ID
+	name := c.state.Name
+	c.mutex.Unlock()
+
+	old.savePlayer(state)
+	record(old, common.RecordEvent{Kind: common.RecordLeave, Name: name})
+	record(w, common.RecordEvent{Kind: common.RecordJoin, Name: name})
+	broadcast("API.PersonDisconnected", name, func(o *connectedPerson) bool {
+		return o != c && o.getWorld() == old
+	})
+	var ret bool
+	e := c.call("API.SetWorld", &common.WorldArgs{Name: w.name, Planets: planets}, &ret)
+	c.mutex.Lock()
+	c.switchingWorld = false
+	c.lastMove = time.Time{}
+	c.mutex.Unlock()
+	return e
+}
+
+// isSwitchingWorld returns whether the person's client is still switching worlds
+func (c *connectedPerson) isSwitchingWorld() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.switchingWorld
+}
+
+func worldCommand(ctx *commandContext, args []string) error {
+	if len(args) == 0 {
+		for _, w := range allWorlds() {
+			ctx.replyf("%v: %v players, %v planets", w.name, len(w.players()), len(w.universe.PlanetMap))
+		}
+		return nil
+	}
+	c, args, e := targetPerson(ctx, args, 1)
+	if e != nil {
+		return e
+	}
+	if c != ctx.person && !ctx.allowed(permTeleport) {
+		return errors.New("you may only move yourself")
+	}
+	w := findWorld(args[0])
+	if w == nil {
+		return errUnknownWorld
+	}
+	if e := c.moveToWorld(w); e != nil {
+		return e
+	}
+	ctx.replyf("Moved %v to world %v", c.getState().Name, w.name)
+	return nil
+}